@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+)
+
+// requireToken only passes requests on to next that carry token as bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pollHandler fetches the users right away and answers once their metrics are updated.
+func pollHandler(e *exporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+
+		e.Poll(r.Context())
+		w.Write([]byte("OK\n"))
+	})
+}
+
+// reloadHandler reloads the configuration with reload and answers with the error if the
+// new configuration is invalid, in which case the running one is kept.
+func reloadHandler(reload func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+
+		if err := reload(); err != nil {
+			slog.Error("An Error Occured, keeping the running configuration", "err", err)
+			http.Error(w, "Failed to reload the configuration: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("OK\n"))
+	})
+}
+
+// quitHandler answers and then calls quit, which shuts the exporter down gracefully.
+func quitHandler(quit func()) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+
+		w.Write([]byte("Requesting termination... Goodbye!\n"))
+		slog.Info("Termination requested on /-/quit")
+		quit()
+	})
+}
+
+// errUserWatched and errUserNotWatched are returned when adding a user that is already
+// watched and removing one that isn't.
+var (
+	errUserWatched    = errors.New("user is already watched")
+	errUserNotWatched = errors.New("user is not watched")
+)
+
+// addUserHandler starts watching the user of the {name} path value, given as in TURF_USERS.
+// A user that is already watched by name can't be added by ID, or the other way round,
+// once it has been fetched.
+func addUserHandler(e *exporter) http.Handler {
+	return updateUserHandler(e, func(refs []turf.UserRef, ref turf.UserRef) ([]turf.UserRef, error) {
+		if findUserRef(refs, ref) >= 0 {
+			return nil, errUserWatched
+		}
+		if user, ok := e.Lookup(ref); ok && slices.ContainsFunc(refs, func(r turf.UserRef) bool { return refersTo(r, user) }) {
+			return nil, errUserWatched
+		}
+		return append(slices.Clone(refs), ref), nil
+	})
+}
+
+// removeUserHandler stops watching the user of the {name} path value.
+func removeUserHandler(e *exporter) http.Handler {
+	return updateUserHandler(e, func(refs []turf.UserRef, ref turf.UserRef) ([]turf.UserRef, error) {
+		i := findUserRef(refs, ref)
+		if i < 0 {
+			return nil, errUserNotWatched
+		}
+		if len(refs) == 1 {
+			return nil, errors.New("the last watched user can't be removed")
+		}
+		return slices.Delete(slices.Clone(refs), i, i+1), nil
+	})
+}
+
+func updateUserHandler(e *exporter, update func([]turf.UserRef, turf.UserRef) ([]turf.UserRef, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ref, err := parseUserRef(r.PathValue("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = e.UpdateWatchedUsers(func(refs []turf.UserRef) ([]turf.UserRef, error) {
+			return update(refs, ref)
+		})
+		switch {
+		case errors.Is(err, errUserWatched):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, errUserNotWatched):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			slog.Info("Updated the watched users", "method", r.Method, "path", r.URL.Path)
+			w.Write([]byte("OK\n"))
+		}
+	})
+}
+
+// requirePost answers requests that aren't POST requests and reports whether r is one.
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+	"github.com/dhose/go-turfgame-exporter/pkg/turf/turftest"
+	"golang.org/x/time/rate"
+)
+
+// TurfClient is the part of the Turf API used by the exporter. It's implemented by
+// *turf.Client and, with TURF_DEMO_ENABLED, by a fake serving made up data.
+type TurfClient interface {
+	Users(ctx context.Context, users []turf.UserRef) ([]turf.User, error)
+	Zones(ctx context.Context, zones []turf.ZoneRef) ([]turf.Zone, error)
+	AllZones(ctx context.Context) ([]turf.Zone, error)
+	Rounds(ctx context.Context) ([]turf.Round, error)
+	Regions(ctx context.Context) ([]turf.Region, error)
+	Toplist(ctx context.Context, scope string, limit int) ([]turf.User, error)
+	Statistics(ctx context.Context) (turf.Statistics, error)
+	Feed(ctx context.Context, feed string, after time.Time) ([]turf.FeedItem, error)
+}
+
+// newTurfClient returns the client used to poll the API.
+func newTurfClient(c Config) TurfClient {
+	if c.TurfDemo {
+		return turftest.NewFake(turftest.Demo())
+	}
+
+	client := turf.NewClient(c.endpoints())
+	client.Timeout = time.Duration(c.ApiTimeoutSec) * time.Second
+	client.CompressRequests = c.ApiCompressRequests
+	client.MaxResponseBytes = int64(c.ApiMaxResponseMB) << 20
+	client.UserAgent = c.ApiUserAgent
+	header, err := parseHeaders(c.ApiHeaders)
+	if err != nil {
+		fatal(err.Error())
+	}
+	client.Header = header
+	transport, err := newTransport(c)
+	if err != nil {
+		fatal(err.Error())
+	}
+	client.HTTPClient.Transport = transport
+	if c.ApiRateLimit > 0 {
+		client.HTTPClient.Transport = limitedTransport{
+			next:    client.HTTPClient.Transport,
+			limiter: rate.NewLimiter(rate.Limit(c.ApiRateLimit), c.ApiRateBurst),
+		}
+	}
+	client.Observe = observeRequest
+	if c.ApiDebugPayloads {
+		client.Failed = logPayloads(c.ApiPayloadFields, c.ApiPayloadBytes)
+	}
+	if c.TracingEnabled {
+		client.Prepare = startTrace
+	}
+
+	if c.BreakerFailures > 0 {
+		return breakerClient{
+			client:  client,
+			breaker: newCircuitBreaker(c.BreakerFailures, time.Duration(c.BreakerCooldownSec)*time.Second),
+		}
+	}
+	return client
+}
+
+// parseHeaders parses headers given as "Name:value".
+func parseHeaders(headers []string) (http.Header, error) {
+	h := make(http.Header)
+	for _, s := range headers {
+		name, value, ok := strings.Cut(s, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q in API_HEADERS, expected Name:value", s)
+		}
+		h.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return h, nil
+}
+
+// apiPaths are the paths of the endpoints used by the exporter, relative to the root of the
+// API version. They are the same in every version, TURF_API_VERSION only selects the root.
+var apiPaths = turf.Endpoints{
+	Users:      "users",
+	Zones:      "zones",
+	Rounds:     "rounds",
+	Regions:    "regions",
+	Toplist:    "users/top",
+	Statistics: "statistics",
+	Feeds:      "feeds",
+}
+
+// resolveEndpoints fills in the endpoint URLs that haven't been configured explicitly from
+// TURF_API_URL and TURF_API_VERSION.
+func (c *Config) resolveEndpoints() error {
+	if c.TurfApiVersion == "" || strings.Contains(c.TurfApiVersion, "/") {
+		return fmt.Errorf("invalid TURF_API_VERSION %q, expected a version such as unstable", c.TurfApiVersion)
+	}
+
+	bases := c.apiBases()
+	if len(bases) == 0 {
+		return errors.New("TURF_API_URL must list at least one URL")
+	}
+	base := bases[0]
+	for _, e := range []struct {
+		url  *string
+		path string
+	}{
+		{&c.TurfApiEndpoint, apiPaths.Users},
+		{&c.TurfZonesApiEndpoint, apiPaths.Zones},
+		{&c.TurfRoundsEndpoint, apiPaths.Rounds},
+		{&c.TurfRegionsEndpoint, apiPaths.Regions},
+		{&c.TurfToplistEndpoint, apiPaths.Toplist},
+		{&c.TurfStatsEndpoint, apiPaths.Statistics},
+		{&c.TurfFeedsEndpoint, apiPaths.Feeds},
+	} {
+		if *e.url == "" {
+			*e.url = base + e.path
+		}
+	}
+	return nil
+}
+
+// apiBases returns the roots of the API version at each URL of TURF_API_URL.
+func (c Config) apiBases() []string {
+	var bases []string
+	for _, u := range c.TurfApiUrl {
+		if strings.TrimSpace(u) == "" {
+			continue
+		}
+		bases = append(bases, strings.TrimSuffix(u, "/")+"/"+c.TurfApiVersion+"/")
+	}
+	return bases
+}
+
+// endpoints returns the resolved endpoint URLs for the API client.
+func (c Config) endpoints() turf.Endpoints {
+	return turf.Endpoints{
+		Users:      c.TurfApiEndpoint,
+		Zones:      c.TurfZonesApiEndpoint,
+		Rounds:     c.TurfRoundsEndpoint,
+		Regions:    c.TurfRegionsEndpoint,
+		Toplist:    c.TurfToplistEndpoint,
+		Statistics: c.TurfStatsEndpoint,
+		Feeds:      c.TurfFeedsEndpoint,
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestResolveEndpoints(t *testing.T) {
+	c := Config{TurfApiUrl: []string{"https://api.turfgame.com/"}, TurfApiVersion: "unstable", TurfFeedsEndpoint: "http://feeds.local/"}
+	if err := c.resolveEndpoints(); err != nil {
+		t.Fatal(err)
+	}
+	if c.TurfApiEndpoint != "https://api.turfgame.com/unstable/users" || c.TurfFeedsEndpoint != "http://feeds.local/" {
+		t.Errorf("got users %q and feeds %q", c.TurfApiEndpoint, c.TurfFeedsEndpoint)
+	}
+
+	for _, c := range []Config{
+		{TurfApiUrl: nil, TurfApiVersion: "unstable"},
+		{TurfApiUrl: []string{""}, TurfApiVersion: "unstable"},
+		{TurfApiUrl: []string{"https://api.turfgame.com"}, TurfApiVersion: ""},
+		{TurfApiUrl: []string{"https://api.turfgame.com"}, TurfApiVersion: "v5/x"},
+	} {
+		if err := c.resolveEndpoints(); err == nil {
+			t.Errorf("resolveEndpoints succeeded for TURF_API_URL %q and TURF_API_VERSION %q", c.TurfApiUrl, c.TurfApiVersion)
+		}
+	}
+}
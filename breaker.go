@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var circuitBreakerState = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "turfgame_api_circuit_breaker_state",
+		Help: "State of the circuit breaker around the Turf API, 0 if closed, 1 if open and 2 if half-open",
+	},
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open, skipping request to the Turf API")
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker stops requests to the API for a cool-down period after a number of
+// consecutive failures. Once the period is over a single request is let through, which
+// closes the circuit if it succeeds.
+type circuitBreaker struct {
+	failures int
+	cooldown time.Duration
+
+	mu          sync.Mutex
+	state       int
+	consecutive int
+	openedAt    time.Time
+}
+
+func newCircuitBreaker(failures int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{failures: failures, cooldown: cooldown}
+}
+
+// Allow reports whether a request may be sent.
+func (b *circuitBreaker) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(circuitHalfOpen)
+		return true
+	case circuitHalfOpen:
+		// The trial request is still in flight.
+		return false
+	}
+	return true
+}
+
+// Done records the outcome of a request that was allowed.
+func (b *circuitBreaker) Done(now time.Time, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil || errors.Is(err, turf.ErrNotModified) {
+		b.consecutive = 0
+		b.setState(circuitClosed)
+		return
+	}
+
+	b.consecutive++
+	if b.state == circuitHalfOpen || b.consecutive >= b.failures {
+		b.openedAt = now
+		b.setState(circuitOpen)
+	}
+}
+
+func (b *circuitBreaker) setState(state int) {
+	b.state = state
+	circuitBreakerState.Set(float64(state))
+}
+
+// breakerClient sends the requests of a TurfClient through a circuit breaker.
+type breakerClient struct {
+	client  TurfClient
+	breaker *circuitBreaker
+}
+
+func withBreaker[T any](b *circuitBreaker, request func() (T, error)) (T, error) {
+	if !b.Allow(time.Now()) {
+		var zero T
+		return zero, errCircuitOpen
+	}
+
+	v, err := request()
+	b.Done(time.Now(), err)
+	return v, err
+}
+
+func (c breakerClient) Users(ctx context.Context, users []turf.UserRef) ([]turf.User, error) {
+	return withBreaker(c.breaker, func() ([]turf.User, error) { return c.client.Users(ctx, users) })
+}
+
+func (c breakerClient) Zones(ctx context.Context, zones []turf.ZoneRef) ([]turf.Zone, error) {
+	return withBreaker(c.breaker, func() ([]turf.Zone, error) { return c.client.Zones(ctx, zones) })
+}
+
+func (c breakerClient) AllZones(ctx context.Context) ([]turf.Zone, error) {
+	return withBreaker(c.breaker, func() ([]turf.Zone, error) { return c.client.AllZones(ctx) })
+}
+
+func (c breakerClient) Rounds(ctx context.Context) ([]turf.Round, error) {
+	return withBreaker(c.breaker, func() ([]turf.Round, error) { return c.client.Rounds(ctx) })
+}
+
+func (c breakerClient) Regions(ctx context.Context) ([]turf.Region, error) {
+	return withBreaker(c.breaker, func() ([]turf.Region, error) { return c.client.Regions(ctx) })
+}
+
+func (c breakerClient) Toplist(ctx context.Context, scope string, limit int) ([]turf.User, error) {
+	return withBreaker(c.breaker, func() ([]turf.User, error) { return c.client.Toplist(ctx, scope, limit) })
+}
+
+func (c breakerClient) Statistics(ctx context.Context) (turf.Statistics, error) {
+	return withBreaker(c.breaker, func() (turf.Statistics, error) { return c.client.Statistics(ctx) })
+}
+
+func (c breakerClient) Feed(ctx context.Context, feed string, after time.Time) ([]turf.FeedItem, error) {
+	return withBreaker(c.breaker, func() ([]turf.FeedItem, error) { return c.client.Feed(ctx, feed, after) })
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// exporter is the prometheus.Collector of the exporter. The per-user gauges are built from
+// the last users snapshot at scrape time, the other metrics are kept up to date by
+// backgroundJob. With SCRAPE_REFRESH_ENABLED a scrape first has the users fetched again,
+// unless they were fetched less than MIN_REFRESH_INTERVAL_SEC ago. The snapshot is left out
+// once it's older than MAX_DATA_AGE_SEC, so that an API outage shows up as gaps, and with
+// METRIC_TIMESTAMPS_ENABLED its metrics carry the time it was fetched. Metrics
+// listed in DISABLE_METRICS are neither registered nor collected. Scrapes during the first
+// STARTUP_WAIT_SEC wait for the first users snapshot, so that they don't record the users
+// as absent.
+type exporter struct {
+	collectors []prometheus.Collector
+	disabled   map[string]bool
+	// known holds the names of the metrics added, including disabled ones.
+	known       map[string]bool
+	medals      bool
+	refresh     bool
+	minInterval time.Duration
+	maxAge      time.Duration
+	refreshCh   chan pollRequest
+	watchCh     chan []turf.UserRef
+	lastFetch   atomic.Int64
+	heartbeat   atomic.Int64
+	// watched holds the watched users, watchMu serializes changing them.
+	watched *userList
+	watchMu sync.Mutex
+	// configured holds TURF_USERS as last loaded.
+	configured []string
+	// ready is closed once the first users snapshot is set.
+	ready     chan struct{}
+	readyOnce sync.Once
+	readyBy   time.Time
+	// mu makes concurrent scrapes share a single refresh.
+	mu sync.Mutex
+	// polling is closed once the refresh in flight, if any, is done.
+	pollMu  sync.Mutex
+	polling chan struct{}
+
+	snapshotMu sync.RWMutex
+	users      []userSnapshot
+	round      string
+	fetched    time.Time
+	timestamps bool
+}
+
+// userSnapshot is a user as last fetched, with the labels of its per-user metrics.
+type userSnapshot struct {
+	turf.User
+	labels prometheus.Labels
+}
+
+func newExporter(c Config) *exporter {
+	disabled := make(map[string]bool)
+	for _, name := range c.DisabledMetrics {
+		disabled[name] = true
+	}
+
+	e := &exporter{
+		disabled:    disabled,
+		known:       make(map[string]bool),
+		medals:      c.TurfUserMedals,
+		refresh:     c.ScrapeRefresh,
+		timestamps:  c.MetricTimestamps,
+		minInterval: time.Duration(c.MinRefreshSec) * time.Second,
+		maxAge:      time.Duration(c.MaxDataAgeSec) * time.Second,
+		refreshCh:   make(chan pollRequest),
+		watchCh:     make(chan []turf.UserRef),
+		watched:     &userList{},
+		configured:  c.TurfUsers,
+		ready:       make(chan struct{}),
+		readyBy:     time.Now().Add(time.Duration(c.StartupWaitSec) * time.Second),
+	}
+	// Starting up, e.g. validating the users, counts as being alive.
+	e.Beat()
+	return e
+}
+
+// Add adds collectors to those collected by the exporter. It must be called before the
+// exporter is registered.
+func (e *exporter) Add(collectors ...prometheus.Collector) {
+	for _, c := range collectors {
+		enabled := false
+		for _, d := range describe(c) {
+			e.known[descName(d)] = true
+			enabled = enabled || !e.disabled[descName(d)]
+		}
+		if enabled {
+			e.collectors = append(e.collectors, c)
+		}
+	}
+}
+
+// CheckDisabled logs the disabled metrics that don't exist.
+func (e *exporter) CheckDisabled() {
+	for _, d := range userDescs {
+		e.known[d.name] = true
+	}
+
+	for name := range e.disabled {
+		if !e.known[name] {
+			slog.Warn("Metric in DISABLE_METRICS is not exported", "metric", name)
+		}
+	}
+}
+
+// Fetched records that the users were fetched at t.
+func (e *exporter) Fetched(t time.Time) {
+	e.lastFetch.Store(t.UnixNano())
+}
+
+// DataAge returns the time since the users were last fetched, and false if they haven't
+// been fetched yet.
+func (e *exporter) DataAge() (time.Duration, bool) {
+	fetched := e.lastFetch.Load()
+	if fetched == 0 {
+		return 0, false
+	}
+	return time.Since(time.Unix(0, fetched)), true
+}
+
+// Beat records that backgroundJob is alive.
+func (e *exporter) Beat() {
+	e.heartbeat.Store(time.Now().UnixNano())
+}
+
+// Stalled returns for how long backgroundJob hasn't been alive if that's longer than max.
+func (e *exporter) Stalled(max time.Duration) (time.Duration, bool) {
+	d := time.Since(time.Unix(0, e.heartbeat.Load()))
+	return d, d > max
+}
+
+// exporterState is the state of the exporter published on /debug/vars.
+type exporterState struct {
+	WatchedUsers int     `json:"watched_users"`
+	Users        int     `json:"users"`
+	Round        string  `json:"round,omitempty"`
+	Fetched      string  `json:"fetched,omitempty"`
+	DataAgeSec   float64 `json:"data_age_sec,omitempty"`
+	HeartbeatSec float64 `json:"heartbeat_sec"`
+	// LastPoll is the summary of the last poll of the users, if any.
+	LastPoll *pollSummary `json:"last_poll,omitempty"`
+}
+
+// State returns the state of the exporter: the watched users, a summary of the users
+// snapshot and of the last poll, and the time since backgroundJob was last alive.
+func (e *exporter) State() exporterState {
+	e.snapshotMu.RLock()
+	state := exporterState{Users: len(e.users), Round: e.round}
+	if !e.fetched.IsZero() {
+		state.Fetched = e.fetched.Format(time.RFC3339)
+	}
+	e.snapshotMu.RUnlock()
+
+	state.WatchedUsers = len(e.watched.Get())
+	if age, ok := e.DataAge(); ok {
+		state.DataAgeSec = age.Seconds()
+	}
+	heartbeat, _ := e.Stalled(0)
+	state.HeartbeatSec = heartbeat.Seconds()
+	state.LastPoll = lastPoll.Load()
+	return state
+}
+
+// SetUsers replaces the users snapshot with users fetched at t. Users missing from users
+// are no longer exported.
+func (e *exporter) SetUsers(users []turf.User, round string, t time.Time) {
+	snapshot := make([]userSnapshot, 0, len(users))
+	for _, user := range users {
+		// A user watched both by name and by ID is returned twice, and its metrics would
+		// collide.
+		if slices.ContainsFunc(snapshot, func(u userSnapshot) bool { return u.Id == user.Id && strings.EqualFold(u.Name, user.Name) }) {
+			continue
+		}
+		snapshot = append(snapshot, userSnapshot{User: user, labels: userLabels.For(user.Name)})
+	}
+
+	e.snapshotMu.Lock()
+	defer e.snapshotMu.Unlock()
+	e.users = snapshot
+	e.round = round
+	e.fetched = t
+	e.readyOnce.Do(func() { close(e.ready) })
+}
+
+// Lookup returns the user of the users snapshot referred to by ref, if any.
+func (e *exporter) Lookup(ref turf.UserRef) (turf.User, bool) {
+	e.snapshotMu.RLock()
+	defer e.snapshotMu.RUnlock()
+
+	i := slices.IndexFunc(e.users, func(u userSnapshot) bool { return refersTo(ref, u.User) })
+	if i < 0 {
+		return turf.User{}, false
+	}
+	return e.users[i].User, true
+}
+
+// RemoveUnwatched removes the users that are not in watched from the snapshot and
+// returns their names.
+func (e *exporter) RemoveUnwatched(watched watchedUsers) []string {
+	e.snapshotMu.Lock()
+	defer e.snapshotMu.Unlock()
+
+	var removed []string
+	e.users = slices.DeleteFunc(e.users, func(u userSnapshot) bool {
+		if _, ok := watched.Lookup(u.Name, u.Id); ok {
+			return false
+		}
+		removed = append(removed, u.Name)
+		return true
+	})
+	return removed
+}
+
+// ReloadUsers replaces the watched users by refs, the users of TURF_USERS given as users
+// after the configuration was reloaded. With keepChanged the watched users are kept if
+// TURF_USERS didn't change since it was last loaded, since they may have been changed at
+// runtime. It reports whether the watched users were replaced. The series of users that
+// are no longer watched are removed.
+func (e *exporter) ReloadUsers(users []string, refs []turf.UserRef, keepChanged bool) bool {
+	e.watchMu.Lock()
+	defer e.watchMu.Unlock()
+
+	if keepChanged && slices.Equal(users, e.configured) {
+		return false
+	}
+	e.configured = users
+	e.watched.Set(refs)
+	e.watchCh <- refs
+	return true
+}
+
+// UpdateWatchedUsers replaces the watched users by those update returns given the current
+// ones, unless it returns an error.
+func (e *exporter) UpdateWatchedUsers(update func([]turf.UserRef) ([]turf.UserRef, error)) error {
+	e.watchMu.Lock()
+	defer e.watchMu.Unlock()
+
+	users, err := update(e.watched.Get())
+	if err != nil {
+		return err
+	}
+	e.watched.Set(users)
+	e.watchCh <- users
+	return nil
+}
+
+func (e *exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range userDescs {
+		if !e.disabled[d.name] {
+			ch <- d.desc
+		}
+	}
+	for _, c := range e.collectors {
+		for _, d := range describe(c) {
+			if !e.disabled[descName(d)] {
+				ch <- d
+			}
+		}
+	}
+}
+
+func (e *exporter) Collect(ch chan<- prometheus.Metric) {
+	e.waitReady()
+
+	e.snapshotMu.RLock()
+	users, round, fetched := e.users, e.round, e.fetched
+	e.snapshotMu.RUnlock()
+
+	if e.maxAge > 0 && time.Since(fetched) > e.maxAge {
+		users = nil
+	}
+	userCh := ch
+	if e.timestamps || slices.ContainsFunc(userDescs, func(d userDesc) bool { return e.disabled[d.name] }) {
+		filtered := make(chan prometheus.Metric)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for m := range filtered {
+				if e.disabled[descName(m.Desc())] {
+					continue
+				}
+				if e.timestamps {
+					m = prometheus.NewMetricWithTimestamp(fetched, m)
+				}
+				ch <- m
+			}
+		}()
+		defer func() {
+			close(filtered)
+			<-done
+		}()
+		userCh = filtered
+	}
+
+	for _, user := range users {
+		collectUserMetrics(userCh, user, round)
+		if e.medals {
+			collectUserMedalMetrics(userCh, user)
+		}
+	}
+	for _, c := range e.collectors {
+		c.Collect(ch)
+	}
+}
+
+// RefreshOnScrape returns a handler that, with SCRAPE_REFRESH_ENABLED, fetches the users
+// before next serves the metrics. It is done here rather than in Collect, which has no
+// request context to carry the trace of the scrape.
+func (e *exporter) RefreshOnScrape(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if e.refresh {
+			e.refreshUsers(r.Context())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refreshUsers asks backgroundJob to fetch the users and waits until the metrics are updated.
+func (e *exporter) refreshUsers(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if time.Since(time.Unix(0, e.lastFetch.Load())) < e.minInterval {
+		return
+	}
+	e.Poll(ctx)
+}
+
+// pollRequest asks backgroundJob to fetch the users. done is closed once the metrics are
+// updated.
+type pollRequest struct {
+	// span is the trace the fetch joins, if ok.
+	span spanContext
+	ok   bool
+	done chan struct{}
+}
+
+// Poll asks backgroundJob to fetch the users and waits until the metrics are updated.
+// Calls made while a fetch is in flight wait for that one instead. The fetch joins the
+// trace of ctx, if any.
+func (e *exporter) Poll(ctx context.Context) {
+	e.pollMu.Lock()
+	done := e.polling
+	if done == nil {
+		done = make(chan struct{})
+		e.polling = done
+		req := pollRequest{done: done}
+		req.span, req.ok = spanFromContext(ctx)
+		go func() {
+			e.refreshCh <- req
+			<-done
+			e.pollMu.Lock()
+			e.polling = nil
+			e.pollMu.Unlock()
+		}()
+	}
+	e.pollMu.Unlock()
+	<-done
+}
+
+// waitReady waits for the first users snapshot, but not past STARTUP_WAIT_SEC after the
+// exporter was created.
+func (e *exporter) waitReady() {
+	wait := time.Until(e.readyBy)
+	if wait <= 0 {
+		return
+	}
+
+	select {
+	case <-e.ready:
+	case <-time.After(wait):
+	}
+}
+
+// userDesc describes a per-user gauge built from the users snapshot at scrape time.
+type userDesc struct {
+	name   string
+	desc   *prometheus.Desc
+	labels []string
+}
+
+func newUserDesc(name string, help string, labels []string) userDesc {
+	return userDesc{
+		name:   name,
+		desc:   prometheus.NewDesc(name, help, labels, nil),
+		labels: labels,
+	}
+}
+
+// metric returns a gauge of d set to value. The label values are taken from labels and
+// the additional name/value pairs, which take precedence. Pairs for labels that d does
+// not have are ignored.
+func (d userDesc) metric(value float64, labels prometheus.Labels, labelValues ...string) prometheus.Metric {
+	values := make([]string, len(d.labels))
+	for i, name := range d.labels {
+		values[i] = labels[name]
+		for j := 0; j+1 < len(labelValues); j += 2 {
+			if labelValues[j] == name {
+				values[i] = labelValues[j+1]
+			}
+		}
+	}
+	return prometheus.MustNewConstMetric(d.desc, prometheus.GaugeValue, value, values...)
+}
+
+// describe returns the descriptors of c.
+func describe(c prometheus.Collector) []*prometheus.Desc {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+
+	var descs []*prometheus.Desc
+	for d := range ch {
+		descs = append(descs, d)
+	}
+	return descs
+}
+
+// descName returns the name of the metric described by d, which prometheus.Desc only
+// exposes through String.
+func descName(d *prometheus.Desc) string {
+	_, s, _ := strings.Cut(d.String(), `fqName: "`)
+	name, _, _ := strings.Cut(s, `"`)
+	return name
+}
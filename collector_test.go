@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+)
+
+func TestExporterReloadUsers(t *testing.T) {
+	e := newExporter(Config{TurfUsers: []string{"Alice"}})
+	go func() {
+		for range e.watchCh {
+		}
+	}()
+	defer close(e.watchCh)
+
+	// Users changed at runtime, e.g. on /api/v1/users.
+	runtime := []turf.UserRef{{Name: "Alice"}, {Name: "Bob"}}
+	e.UpdateWatchedUsers(func([]turf.UserRef) ([]turf.UserRef, error) { return runtime, nil })
+
+	if e.ReloadUsers([]string{"Alice"}, []turf.UserRef{{Name: "Alice"}}, true) {
+		t.Error("unchanged TURF_USERS replaced the users changed at runtime")
+	}
+	if got := e.watched.Get(); !slices.Equal(got, runtime) {
+		t.Errorf("got watched users %v, want %v", got, runtime)
+	}
+
+	carol := []turf.UserRef{{Name: "Carol"}}
+	if !e.ReloadUsers([]string{"Carol"}, carol, true) {
+		t.Error("changed TURF_USERS didn't replace the watched users")
+	}
+	if got := e.watched.Get(); !slices.Equal(got, carol) {
+		t.Errorf("got watched users %v, want %v", got, carol)
+	}
+
+	if !e.ReloadUsers([]string{"Carol"}, carol, false) {
+		t.Error("without PERSIST_USERS_ENABLED the watched users weren't replaced")
+	}
+}
+
+func TestExporterSetUsersDuplicates(t *testing.T) {
+	e := newExporter(Config{TurfUsers: []string{"Alice", "id:1001"}})
+	alice := turf.User{Name: "Alice", Id: 1001}
+	e.SetUsers([]turf.User{alice, {Name: "Bob", Id: 1002}, alice}, "", time.Now())
+
+	if len(e.users) != 2 {
+		t.Errorf("got %d users in the snapshot, want Alice once and Bob", len(e.users))
+	}
+	if user, ok := e.Lookup(turf.UserRef{Id: 1001}); !ok || user.Name != "Alice" {
+		t.Errorf("got %v, %v for id:1001, want Alice", user, ok)
+	}
+	if _, ok := e.Lookup(turf.UserRef{Name: "Carol"}); ok {
+		t.Error("found Carol, who isn't in the snapshot")
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sethvargo/go-envconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig loads the configuration from flags, the environment and the YAML file at
+// configPath, in that order of precedence. A setting that isn't given may be read from the
+// file that the environment variable named like it with _FILE appended points to, e.g.
+// TURF_USERS_FILE, as is common for Docker and Kubernetes secrets.
+func loadConfig(ctx context.Context, configPath string, flags map[string]string) (Config, error) {
+	var file map[string]string
+	if configPath != "" {
+		var err error
+		if file, err = readConfigFile(configPath); err != nil {
+			return Config{}, err
+		}
+	}
+	lookuper := envconfig.MultiLookuper(envconfig.MapLookuper(flags), envconfig.OsLookuper(), envconfig.MapLookuper(file))
+
+	secrets, err := readSecretFiles(lookuper)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var c Config
+	err = envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target:   &c,
+		Lookuper: envconfig.MultiLookuper(lookuper, envconfig.MapLookuper(secrets)),
+	})
+	return c, err
+}
+
+// reloadConfig loads the configuration again and watches the users of it from then on, see
+// exporter.ReloadUsers. The other settings that differ from those of running are logged,
+// as they only take effect after a restart.
+func reloadConfig(ctx context.Context, configPath string, flags map[string]string, running Config, e *exporter) error {
+	c, err := loadConfig(ctx, configPath, flags)
+	if err != nil {
+		return err
+	}
+	if err := c.resolveEndpoints(); err != nil {
+		return err
+	}
+	refs, err := parseUserRefs(c.TurfUsers)
+	if err != nil {
+		return err
+	}
+
+	t := reflect.TypeOf(c)
+	for i := range t.NumField() {
+		env := envName(t.Field(i))
+		if env != "TURF_USERS" && !reflect.DeepEqual(reflect.ValueOf(c).Field(i).Interface(), reflect.ValueOf(running).Field(i).Interface()) {
+			slog.Warn("Setting changed, which takes effect after a restart", "env", env)
+		}
+	}
+
+	// With PERSIST_USERS_ENABLED the users changed at runtime are only replaced if TURF_USERS
+	// changed, not on every reload.
+	if e.ReloadUsers(c.TurfUsers, refs, running.PersistUsers) {
+		if running.PersistUsers {
+			slog.Warn("TURF_USERS changed, the users changed at runtime are replaced by it")
+		}
+	} else {
+		slog.Info("TURF_USERS is unchanged, keeping the watched users")
+	}
+	slog.Info("Reloaded the configuration")
+	return nil
+}
+
+// secretSettings are the settings whose values effectiveConfig redacts, and userSettings
+// those it redacts with TURF_ANONYMIZE_USERS.
+var (
+	secretSettings = []string{"ADMIN_TOKEN", "API_HEADERS", "API_PROXY_PASSWORD", "TURF_ANONYMIZE_SALT"}
+	userSettings   = []string{"TURF_USERS", "TURF_PRIORITY_USERS", "TURF_TEAMS", "TURF_USER_LABELS"}
+)
+
+// effectiveConfig returns the settings of c as KEY=value lines, in the order of Config. The
+// values of secrets and the passwords in URLs are redacted, as are the users if they are
+// anonymized.
+func effectiveConfig(c Config) string {
+	var b strings.Builder
+	v := reflect.ValueOf(c)
+	for i := range v.NumField() {
+		env := envName(v.Type().Field(i))
+		value := formatSetting(v.Field(i))
+		if value != "" && (slices.Contains(secretSettings, env) || c.TurfAnonymizeUsers != "" && slices.Contains(userSettings, env)) {
+			value = "<redacted>"
+		}
+		fmt.Fprintf(&b, "%s=%s\n", env, value)
+	}
+	return b.String()
+}
+
+// formatSetting formats v as in an environment variable, with lists separated by commas.
+func formatSetting(v reflect.Value) string {
+	if v.Kind() == reflect.Slice {
+		values := make([]string, v.Len())
+		for i := range values {
+			values[i] = formatSetting(v.Index(i))
+		}
+		return strings.Join(values, ",")
+	}
+	s := fmt.Sprint(v.Interface())
+	if u, err := url.Parse(s); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return s
+}
+
+// watchConfigFile calls reload whenever the contents of the file at path change. The
+// directory of the file is watched rather than the file itself, so that files replaced by
+// renaming, as in the volumes of Kubernetes ConfigMaps, are followed.
+func watchConfigFile(ctx context.Context, path string, reload func() error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Error("An Error Occured, not watching the config file", "path", path, "err", err)
+		return
+	}
+	defer w.Close()
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		slog.Error("An Error Occured, not watching the config file", "path", path, "err", err)
+		return
+	}
+
+	last, _ := os.ReadFile(path)
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-w.Errors:
+			slog.Error("An Error Occured", "err", err)
+		case <-w.Events:
+			// Editors and ConfigMap updates change the file in several steps.
+			settled = time.After(time.Second)
+		case <-settled:
+			settled = nil
+			b, err := os.ReadFile(path)
+			if err != nil {
+				slog.Error("An Error Occured", "err", err)
+				continue
+			}
+			if bytes.Equal(b, last) {
+				continue
+			}
+			last = b
+
+			slog.Info("Config file changed", "path", path)
+			if err := reload(); err != nil {
+				slog.Error("An Error Occured, keeping the running configuration", "err", err)
+			}
+		}
+	}
+}
+
+// readSecretFiles returns the contents of the files pointed to by the _FILE variables of the
+// settings that l doesn't have, without the trailing newline.
+func readSecretFiles(l envconfig.Lookuper) (map[string]string, error) {
+	secrets := make(map[string]string)
+
+	t := reflect.TypeOf(Config{})
+	for i := range t.NumField() {
+		env := envName(t.Field(i))
+		path, ok := l.Lookup(env + "_FILE")
+		if _, set := l.Lookup(env); set || !ok {
+			continue
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s_FILE: %w", env, err)
+		}
+		secrets[env] = strings.TrimRight(string(b), "\r\n")
+	}
+	return secrets, nil
+}
+
+// envName returns the environment variable of a field of Config.
+func envName(f reflect.StructField) string {
+	env, _, _ := strings.Cut(f.Tag.Get("env"), ",")
+	return env
+}
+
+// configFile is the YAML file given with --config. Its settings are turned into the
+// environment variables they correspond to, so that environment variables set as well
+// override them.
+type configFile struct {
+	Users []struct {
+		Name     string `yaml:"name"`
+		ID       int    `yaml:"id"`
+		Priority bool   `yaml:"priority"`
+		// Team and Labels need the user to be given by name.
+		Team   string            `yaml:"team"`
+		Labels map[string]string `yaml:"labels"`
+	} `yaml:"users"`
+	Collectors struct {
+		Zones      []string `yaml:"zones"`
+		Rounds     *bool    `yaml:"rounds"`
+		Regions    []string `yaml:"regions"`
+		Toplists   []string `yaml:"toplists"`
+		Statistics *bool    `yaml:"statistics"`
+		Feeds      []string `yaml:"feeds"`
+		UserZones  *bool    `yaml:"user_zones"`
+		UserMedals *bool    `yaml:"user_medals"`
+	} `yaml:"collectors"`
+	Intervals struct {
+		Poll       int `yaml:"poll"`
+		Users      int `yaml:"users"`
+		Zones      int `yaml:"zones"`
+		Rounds     int `yaml:"rounds"`
+		Regions    int `yaml:"regions"`
+		Toplists   int `yaml:"toplists"`
+		Statistics int `yaml:"statistics"`
+		Feeds      int `yaml:"feeds"`
+		Priority   int `yaml:"priority"`
+	} `yaml:"intervals"`
+	// Env holds any other settings by environment variable.
+	Env map[string]string `yaml:"env"`
+}
+
+// readConfigFile reads the YAML file at path and returns its settings by environment
+// variable.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f configFile
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	d.KnownFields(true)
+	if err := d.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	settings, err := f.settings()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return settings, nil
+}
+
+func (f configFile) settings() (map[string]string, error) {
+	env := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" {
+			env[name] = value
+		}
+	}
+
+	var users, priority, labels, teamNames []string
+	teams := make(map[string][]string)
+	for _, u := range f.Users {
+		if (u.Name == "") == (u.ID == 0) {
+			return nil, errors.New("each user needs either a name or an id")
+		}
+		user := u.Name
+		if u.Name == "" {
+			if u.Team != "" || len(u.Labels) > 0 {
+				return nil, fmt.Errorf("user id %d needs a name for its team and labels", u.ID)
+			}
+			user = "id:" + strconv.Itoa(u.ID)
+		} else if err := listable(u.Name, u.Team); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+		if u.Priority {
+			priority = append(priority, user)
+		}
+
+		if u.Team != "" {
+			if _, ok := teams[u.Team]; !ok {
+				teamNames = append(teamNames, u.Team)
+			}
+			teams[u.Team] = append(teams[u.Team], u.Name)
+		}
+
+		if len(u.Labels) > 0 {
+			var l []string
+			for _, name := range sortedKeys(u.Labels) {
+				if err := listable(name, u.Labels[name]); err != nil {
+					return nil, err
+				}
+				l = append(l, name+"="+u.Labels[name])
+			}
+			labels = append(labels, u.Name+":"+strings.Join(l, ","))
+		}
+	}
+	var t []string
+	for _, name := range teamNames {
+		t = append(t, name+":"+strings.Join(teams[name], ","))
+	}
+
+	set("TURF_USERS", strings.Join(users, ","))
+	set("TURF_PRIORITY_USERS", strings.Join(priority, ","))
+	set("TURF_TEAMS", strings.Join(t, ";"))
+	set("TURF_USER_LABELS", strings.Join(labels, ";"))
+
+	c := f.Collectors
+	set("TURF_ZONES", strings.Join(c.Zones, ","))
+	set("TURF_ROUNDS_ENABLED", formatBool(c.Rounds))
+	set("TURF_REGIONS", strings.Join(c.Regions, ","))
+	set("TURF_TOPLISTS", strings.Join(c.Toplists, ","))
+	set("TURF_STATISTICS_ENABLED", formatBool(c.Statistics))
+	set("TURF_FEEDS", strings.Join(c.Feeds, ","))
+	set("TURF_USER_ZONES_ENABLED", formatBool(c.UserZones))
+	set("TURF_USER_MEDALS_ENABLED", formatBool(c.UserMedals))
+
+	i := f.Intervals
+	set("POLL_INTERVAL_SEC", formatInterval(i.Poll))
+	set("USERS_POLL_INTERVAL_SEC", formatInterval(i.Users))
+	set("ZONES_POLL_INTERVAL_SEC", formatInterval(i.Zones))
+	set("ROUNDS_POLL_INTERVAL_SEC", formatInterval(i.Rounds))
+	set("REGIONS_POLL_INTERVAL_SEC", formatInterval(i.Regions))
+	set("TOPLISTS_POLL_INTERVAL_SEC", formatInterval(i.Toplists))
+	set("STATISTICS_POLL_INTERVAL_SEC", formatInterval(i.Statistics))
+	set("FEEDS_POLL_INTERVAL_SEC", formatInterval(i.Feeds))
+	set("PRIORITY_POLL_INTERVAL_SEC", formatInterval(i.Priority))
+
+	for name, value := range f.Env {
+		if _, ok := env[name]; ok {
+			return nil, fmt.Errorf("%s in env is already set by the rest of the file", name)
+		}
+		env[name] = value
+	}
+	return env, nil
+}
+
+// listable returns an error if any of values can't be part of the lists of TURF_TEAMS and
+// TURF_USER_LABELS.
+func listable(values ...string) error {
+	for _, v := range values {
+		if strings.ContainsAny(v, ",;:=") {
+			return fmt.Errorf("%q must not contain any of , ; : =", v)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func formatBool(b *bool) string {
+	if b == nil {
+		return ""
+	}
+	return strconv.FormatBool(*b)
+}
+
+func formatInterval(sec int) string {
+	if sec == 0 {
+		return ""
+	}
+	return strconv.Itoa(sec)
+}
+
+// envFlags defines a flag on fs for each environment variable of Config, named in the style
+// of Prometheus exporters, e.g. --turf.users for TURF_USERS, and a few flags named like those
+// of other exporters. It returns the values of the flags that are set by environment variable.
+func envFlags(fs *flag.FlagSet) map[string]string {
+	values := make(map[string]string)
+
+	t := reflect.TypeOf(Config{})
+	for i := range t.NumField() {
+		tag := t.Field(i).Tag.Get("env")
+		env := envName(t.Field(i))
+		usage := "Sets " + env
+		if _, def, ok := strings.Cut(tag, "default="); ok {
+			usage += " (default " + def + ")"
+		}
+		set := func(v string) error {
+			values[env] = v
+			return nil
+		}
+		if t.Field(i).Type.Kind() == reflect.Bool {
+			fs.BoolFunc(flagName(env), usage, set)
+		} else {
+			fs.Func(flagName(env), usage, set)
+		}
+	}
+
+	fs.Func("web.config.file", "Path of the web configuration file, sets WEB_CONFIG_FILE", func(v string) error {
+		values["WEB_CONFIG_FILE"] = v
+		return nil
+	})
+	fs.Func("poll.interval", "Time between polls, e.g. 5m, sets POLL_INTERVAL_SEC", func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		values["POLL_INTERVAL_SEC"] = strconv.Itoa(int(d.Seconds()))
+		return nil
+	})
+
+	return values
+}
+
+// flagName returns the flag of the environment variable env, e.g. api.timeout-sec for
+// API_TIMEOUT_SEC.
+func flagName(env string) string {
+	prefix, rest, _ := strings.Cut(strings.ToLower(env), "_")
+	if rest == "" {
+		return prefix
+	}
+	return prefix + "." + strings.ReplaceAll(rest, "_", "-")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// dryRun polls the users once and writes the metrics gathered by g to w in the text
+// exposition format. It fails if the poll failed or if some of the users were not returned.
+func dryRun(ctx context.Context, c Config, e *exporter, g prometheus.Gatherer, w io.Writer) error {
+	refs, err := parseUserRefs(c.TurfUsers)
+	if err != nil {
+		return err
+	}
+	pollWorkers = newWorkerPool(c.ApiWorkers)
+	updateConfigMetrics(c, len(refs))
+
+	client := newTurfClient(c)
+	start := time.Now()
+	users, err := fetchWithRetries(ctx, c, func(ctx context.Context) ([]turf.User, error) {
+		return client.Users(ctx, refs)
+	})
+	observeCycle(time.Since(start), len(refs), users, err)
+	observePoll(c.TurfApiEndpoint, err)
+	if err != nil {
+		return err
+	}
+
+	if c.TurfCountryLabel {
+		for _, user := range users {
+			userLabels.Set(user.Name, "country", user.Country)
+		}
+	}
+	fetched := time.Now()
+	e.Fetched(fetched)
+	e.SetUsers(users, "", fetched)
+	updateUserFound(refs, users)
+
+	mfs, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			return err
+		}
+	}
+
+	var missing []string
+	for _, ref := range refs {
+		if findUser(users, ref) < 0 {
+			missing = append(missing, formatUserRef(ref))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("users %s not returned by the Turf API", strings.Join(missing, ", "))
+	}
+	return nil
+}
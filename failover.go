@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var activeApiUrl = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "turfgame_api_url_active",
+		Help: "Whether the API root at a URL of TURF_API_URL is the one currently requested, 1 if it is",
+	},
+	[]string{"url"},
+)
+
+// failoverTransport sends the requests for the first of urls to the one currently in use
+// instead. If it can't be reached, the request is sent to the next ones in turn, and the
+// first that answers is used from then on.
+type failoverTransport struct {
+	next   http.RoundTripper
+	urls   []string
+	active atomic.Int64
+}
+
+func newFailoverTransport(next http.RoundTripper, urls []string) *failoverTransport {
+	t := &failoverTransport{next: next, urls: urls}
+	t.setActive(0)
+	return t
+}
+
+func (t *failoverTransport) setActive(n int) {
+	t.active.Store(int64(n))
+	for i, u := range t.urls {
+		activeApiUrl.WithLabelValues(u).Set(0)
+		if i == n {
+			activeApiUrl.WithLabelValues(u).Set(1)
+		}
+	}
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path, ok := strings.CutPrefix(req.URL.String(), t.urls[0])
+	if !ok {
+		// E.g. an endpoint set by its own TURF_API_*_URL.
+		return t.next.RoundTrip(req)
+	}
+
+	active := int(t.active.Load())
+	var err error
+	for i := range t.urls {
+		n := (active + i) % len(t.urls)
+
+		r := req.Clone(req.Context())
+		if r.URL, err = url.Parse(t.urls[n] + path); err != nil {
+			return nil, err
+		}
+		r.Host = ""
+		if req.GetBody != nil {
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		var resp *http.Response
+		resp, err = t.next.RoundTrip(r)
+		if err == nil {
+			if n != active {
+				slog.Warn("Switched to another API URL", "url", t.urls[n])
+				t.setActive(n)
+			}
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		slog.Warn("An Error Occured, trying the next API URL", "url", t.urls[n], "error_class", errorClass(err), "err", err)
+	}
+	return nil, err
+}
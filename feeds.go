@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+)
+
+// feedEvents are new events of a feed, in the order they happened.
+type feedEvents struct {
+	feed  string
+	items []turf.FeedItem
+}
+
+// pollFeed requests the feed every FeedsIntervalSec, or PollIntervalSec if it's 0, and
+// passes events newer than the previous request on ch until ctx is cancelled. The first
+// request asks for the events after after or, if it's zero, after the exporter started.
+func pollFeed(ctx context.Context, c Config, client TurfClient, feed string, after time.Time, ch chan feedEvents) {
+	if after.IsZero() {
+		after = time.Now()
+	}
+
+	for {
+		items, err := fetchWithRetries(ctx, c, func(ctx context.Context) ([]turf.FeedItem, error) {
+			return client.Feed(ctx, feed, after)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		observePoll(strings.TrimSuffix(c.TurfFeedsEndpoint, "/")+"/"+feed, err)
+		if err != nil {
+			slog.Error("An Error Occured", "feed", feed, "error_class", errorClass(err), "err", err)
+		} else {
+			var fresh []turf.FeedItem
+			for _, item := range items {
+				if !item.Time.After(after) {
+					continue
+				}
+				fresh = append(fresh, item)
+			}
+
+			if len(fresh) > 0 {
+				// The feed lists the newest events first, hand them on in the order they happened.
+				slices.SortFunc(fresh, func(a, b turf.FeedItem) int { return a.Time.Compare(b.Time.Time) })
+				after = fresh[len(fresh)-1].Time.Time
+				ch <- feedEvents{feed: feed, items: fresh}
+			}
+		}
+
+		if !waitNext(ctx, c.schedule(c.FeedsIntervalSec), err) {
+			return
+		}
+	}
+}
+
+func updateFeedMetrics(items []turf.FeedItem, watched watchedUsers, activity activityTracker) {
+	for _, item := range items {
+		switch item.Type {
+		case "takeover":
+			if user, ok := watched.Lookup(item.Taker().Name, item.Taker().Id); ok {
+				userTakeovers.With(userLabels.For(user)).Inc()
+				activity.Observe(user, item.Time.Time)
+			}
+			if user, ok := watched.Lookup(item.Loser().Name, item.Loser().Id); ok {
+				userZonesLostEvents.With(userLabels.For(user, "zone_name", item.Zone.Name)).Inc()
+			}
+			for _, a := range item.Assists {
+				if user, ok := watched.Lookup(a.Name, a.Id); ok {
+					userAssists.With(userLabels.For(user)).Inc()
+					activity.Observe(user, item.Time.Time)
+				}
+			}
+		case "medal":
+			if user, ok := watched.Lookup(item.User.Name, item.User.Id); ok {
+				userMedalEvents.With(userLabels.For(user)).Inc()
+				userLastMedal.With(userLabels.For(user)).Set(float64(item.Time.Unix()))
+				activity.Observe(user, item.Time.Time)
+			}
+		case "chat":
+			chatMessages.WithLabelValues(item.Region.Name).Inc()
+		}
+	}
+}
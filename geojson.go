@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// ownedZones holds the latest resolved zones of the watched users for the /geojson endpoint.
+var ownedZones struct {
+	sync.RWMutex
+	users []userZones
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string         `json:"type"`
+	Geometry   geoJSONPoint   `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+func setOwnedZones(users []userZones) {
+	ownedZones.Lock()
+	defer ownedZones.Unlock()
+	ownedZones.users = users
+}
+
+// geoJSONHandler serves the zones owned by the watched users as a GeoJSON FeatureCollection,
+// e.g. for a Grafana Geomap panel.
+func geoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	ownedZones.RLock()
+	defer ownedZones.RUnlock()
+
+	fc := geoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []geoJSONFeature{},
+	}
+	for _, u := range ownedZones.users {
+		for _, zone := range u.Zones {
+			fc.Features = append(fc.Features, geoJSONFeature{
+				Type: "Feature",
+				Geometry: geoJSONPoint{
+					Type:        "Point",
+					Coordinates: []float64{zone.Longitude, zone.Latitude},
+				},
+				Properties: map[string]any{
+					"user":          userAliases.Name(u.User),
+					"zone_name":     zone.Name,
+					"zone_id":       zone.Id,
+					"pointsPerHour": zone.PointsPerHour,
+					"region":        zone.Region.Name,
+				},
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	if err := json.NewEncoder(w).Encode(fc); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
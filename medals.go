@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Medal maps a medal ID, as listed in a users medals, to its name.
+type Medal struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	// UniqueZones is the number of unique zones needed for the medal, if it's a unique-zone medal.
+	UniqueZones int `json:"uniqueZones"`
+}
+
+// medals holds the medals of TURF_MEDALS_FILE. The Turf API only lists the IDs of the medals
+// of a user, so their names are only known from such a mapping.
+var medals []Medal
+
+// loadMedals reads the medals mapping at path, a JSON array of medals.
+func loadMedals(path string) ([]Medal, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m []Medal
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("invalid TURF_MEDALS_FILE %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// collectUserMedalMetrics sends one series per medal of TURF_MEDALS_FILE of user on ch, set
+// to 1 if the user has taken it. Medals missing from the mapping are sent without a name.
+func collectUserMedalMetrics(ch chan<- prometheus.Metric, user userSnapshot) {
+	for _, m := range medals {
+		value := 0.0
+		if slices.Contains(user.Medals, m.Id) {
+			value = 1
+		}
+		ch <- userMedal.metric(value, user.labels, "medal_id", strconv.Itoa(m.Id), "medal_name", m.Name)
+	}
+
+	for _, id := range user.Medals {
+		if !slices.ContainsFunc(medals, func(m Medal) bool { return m.Id == id }) {
+			ch <- userMedal.metric(1, user.labels, "medal_id", strconv.Itoa(id), "medal_name", "")
+		}
+	}
+}
+
+// uniqueZonesToNextMedal returns how many more unique zones are needed for the next
+// unique-zone medal of TURF_MEDALS_FILE, or 0 if all of them have been reached. It returns
+// false if the file lists no unique-zone medals, as their thresholds aren't known then.
+func uniqueZonesToNextMedal(taken int) (int, bool) {
+	next, known := 0, false
+	for _, m := range medals {
+		if m.UniqueZones == 0 {
+			continue
+		}
+		known = true
+		if m.UniqueZones > taken && (next == 0 || m.UniqueZones < next) {
+			next = m.UniqueZones
+		}
+	}
+
+	if next == 0 {
+		return 0, known
+	}
+	return next - taken, known
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// logPayloads returns a turf.Client.Failed that logs the bodies of failed requests to the
+// Turf API and of their responses at debug level, redacted by redactPayload and truncated
+// to max bytes.
+func logPayloads(fields []string, max int) func(req *http.Request, reqBody []byte, respBody []byte, err error) {
+	allowed := make(map[string]bool)
+	for _, field := range fields {
+		allowed[field] = true
+	}
+
+	return func(req *http.Request, reqBody []byte, respBody []byte, err error) {
+		slog.Debug("Request to the Turf API failed", "endpoint", req.URL, "error_class", errorClass(err), "err", err,
+			"request_body", redactPayload(reqBody, allowed, max), "response_body", redactPayload(respBody, allowed, max))
+	}
+}
+
+// redactPayload returns body with the values of the JSON fields not in allowed replaced by
+// their type, e.g. <string>, so that the structure of the body shows but not the data.
+// Bodies that aren't JSON, e.g. error pages of a proxy, are returned as they are, unless they
+// look like JSON that was cut off, which can't be redacted.
+func redactPayload(body []byte, allowed map[string]bool, max int) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && strings.ContainsRune("{[\"", rune(trimmed[0])) {
+			return fmt.Sprintf("<invalid JSON of %d bytes>", len(body))
+		}
+		return truncate(string(body), max)
+	}
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	// Keep the placeholders readable rather than escaped as \u003cstring\u003e.
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(redact(v, allowed, false)); err != nil {
+		return ""
+	}
+	return truncate(strings.TrimSuffix(b.String(), "\n"), max)
+}
+
+// redact replaces the values in v that aren't kept by their type. The values of the fields
+// in allowed are kept, including those nested in them if they are arrays.
+func redact(v any, allowed map[string]bool, keep bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for name, value := range v {
+			v[name] = redact(value, allowed, allowed[name])
+		}
+		return v
+	case []any:
+		for i, value := range v {
+			v[i] = redact(value, allowed, keep)
+		}
+		return v
+	case nil:
+		return nil
+	}
+
+	if keep {
+		return v
+	}
+	switch v.(type) {
+	case string:
+		return "<string>"
+	case float64:
+		return "<number>"
+	case bool:
+		return "<bool>"
+	}
+	return v
+}
+
+// truncate returns s cut to max bytes, marked with ... if it was cut.
+func truncate(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	return strings.ToValidUTF8(s[:max], "") + "..."
+}
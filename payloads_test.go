@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestRedactPayload(t *testing.T) {
+	allowed := map[string]bool{"errorMessage": true, "name": true}
+	tests := []struct {
+		name string
+		body string
+		max  int
+		want string
+	}{
+		{name: "empty", body: "", want: ""},
+		{
+			name: "allowed fields kept",
+			body: `{"errorMessage": "Unknown user <Al>", "id": 1001, "active": true}`,
+			want: `{"active":"<bool>","errorMessage":"Unknown user <Al>","id":"<number>"}`,
+		},
+		{
+			name: "nested",
+			body: `[{"name": "Alice", "region": {"name": "Stockholm", "id": 141}, "zones": [501, 502], "medal": null}]`,
+			want: `[{"medal":null,"name":"Alice","region":{"id":"<number>","name":"Stockholm"},"zones":["<number>","<number>"]}]`,
+		},
+		{name: "not JSON", body: "<html>Bad gateway</html>", want: "<html>Bad gateway</html>"},
+		{name: "cut off JSON", body: `[{"name": "Al`, want: "<invalid JSON of 13 bytes>"},
+		{name: "truncated", body: "<html>Bad gateway</html>", max: 10, want: "<html>Bad ..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactPayload([]byte(tt.body), allowed, tt.max); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
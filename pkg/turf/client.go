@@ -0,0 +1,489 @@
+// Package turf is a client for the Turf API, see https://api.turfgame.com.
+package turf
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoints are the URLs of the API endpoints used by the client.
+type Endpoints struct {
+	Users      string
+	Zones      string
+	Rounds     string
+	Regions    string
+	Toplist    string
+	Statistics string
+	Feeds      string
+}
+
+// Client sends requests to the Turf API. Responses are requested gzipped and decompressed
+// by the transport of HTTPClient, as http.Transport does unless DisableCompression is set.
+type Client struct {
+	HTTPClient *http.Client
+	Endpoints  Endpoints
+	// CompressRequests gzips the bodies of POST requests, if set.
+	CompressRequests bool
+	// UserAgent, if set, is sent as User-Agent header. The Turf API asks clients to
+	// identify themselves.
+	UserAgent string
+	// Header holds additional headers sent with each request.
+	Header http.Header
+	// MaxResponseBytes, if set, limits the size of the responses read.
+	MaxResponseBytes int64
+	// Timeout limits the time of each request, including reading the response, if set.
+	Timeout time.Duration
+	// Prepare, if set, is called with each request before it's sent, e.g. to add headers.
+	Prepare func(req *http.Request)
+	// Observe, if set, is called after each request with the request, the response, if
+	// any, the time it took to get the response and the error, if any, of sending the
+	// request or decoding the response.
+	Observe func(req *http.Request, resp *http.Response, d time.Duration, err error)
+	// Failed, if set, is called after each request that failed, other than with
+	// ErrNotModified, with the request, its body before compression and the first MiB of the
+	// body of the response, if any, e.g. to log them.
+	Failed func(req *http.Request, reqBody []byte, respBody []byte, err error)
+
+	mu sync.Mutex
+	// validators holds the ETag and Last-Modified headers of the responses to conditional
+	// requests, by URL.
+	validators map[string]http.Header
+}
+
+// NewClient returns a client for endpoints with a request timeout of 10 seconds.
+func NewClient(endpoints Endpoints) *Client {
+	return &Client{
+		HTTPClient: &http.Client{},
+		Endpoints:  endpoints,
+		Timeout:    10 * time.Second,
+	}
+}
+
+// UserRef identifies a user by name or, if Id is set, by Turf ID.
+type UserRef struct {
+	Name string
+	Id   int
+}
+
+// MarshalJSON encodes the reference the way the users endpoint expects it.
+func (u UserRef) MarshalJSON() ([]byte, error) {
+	if u.Id != 0 {
+		return json.Marshal(map[string]int{"id": u.Id})
+	}
+	return json.Marshal(map[string]string{"name": u.Name})
+}
+
+// ZoneRef identifies a zone by name or, if Id is set, by zone ID.
+type ZoneRef struct {
+	Name string
+	Id   int
+}
+
+// MarshalJSON encodes the reference the way the zones endpoint expects it.
+func (z ZoneRef) MarshalJSON() ([]byte, error) {
+	if z.Id != 0 {
+		return json.Marshal(map[string]int{"id": z.Id})
+	}
+	return json.Marshal(map[string]string{"name": z.Name})
+}
+
+// Users returns the users referred to by users.
+func (c *Client) Users(ctx context.Context, users []UserRef) ([]User, error) {
+	var v []User
+	err := c.do(ctx, http.MethodPost, c.Endpoints.Users, users, &v)
+	return v, err
+}
+
+// Zones returns the zones referred to by zones.
+func (c *Client) Zones(ctx context.Context, zones []ZoneRef) ([]Zone, error) {
+	var v []Zone
+	err := c.do(ctx, http.MethodPost, c.Endpoints.Zones, zones, &v)
+	return v, err
+}
+
+// AllZones returns every zone in the game.
+func (c *Client) AllZones(ctx context.Context) ([]Zone, error) {
+	var v []Zone
+	err := c.do(ctx, http.MethodGet, c.AllZonesURL(), nil, &v)
+	return v, err
+}
+
+// AllZonesURL returns the URL requested by AllZones.
+func (c *Client) AllZonesURL() string {
+	return strings.TrimSuffix(c.Endpoints.Zones, "/") + "/all"
+}
+
+// Rounds returns the rounds, ordered by their start.
+func (c *Client) Rounds(ctx context.Context) ([]Round, error) {
+	var v []Round
+	err := c.do(ctx, http.MethodGet, c.Endpoints.Rounds, nil, &v)
+	return v, err
+}
+
+// Regions returns every region in the game.
+func (c *Client) Regions(ctx context.Context) ([]Region, error) {
+	var v []Region
+	err := c.do(ctx, http.MethodGet, c.Endpoints.Regions, nil, &v)
+	return v, err
+}
+
+// Toplist returns the top limit players of scope, which is "global", "country/<code>"
+// or "region/<id>".
+func (c *Client) Toplist(ctx context.Context, scope string, limit int) ([]User, error) {
+	u, err := c.ToplistURL(scope, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var v []User
+	err = c.do(ctx, http.MethodGet, u, nil, &v)
+	return v, err
+}
+
+// ToplistURL returns the URL requested by Toplist.
+func (c *Client) ToplistURL(scope string, limit int) (string, error) {
+	u, err := url.Parse(c.Endpoints.Toplist)
+	if err != nil {
+		return "", err
+	}
+
+	kind, value, err := ParseScope(scope)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	if kind != "global" {
+		q.Set(kind, value)
+	}
+	q.Set("limit", strconv.Itoa(limit))
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// ParseScope splits a toplist scope into its kind, "global", "country" or "region", and
+// the country code or region ID.
+func ParseScope(scope string) (kind string, value string, err error) {
+	kind, value, _ = strings.Cut(scope, "/")
+	switch {
+	case kind == "global" && value == "":
+	case (kind == "country" || kind == "region") && value != "":
+	default:
+		return "", "", fmt.Errorf("invalid toplist scope %q", scope)
+	}
+	return kind, value, nil
+}
+
+// Statistics returns the global statistics of the game.
+func (c *Client) Statistics(ctx context.Context) (Statistics, error) {
+	var v Statistics
+	err := c.do(ctx, http.MethodGet, c.Endpoints.Statistics, nil, &v)
+	return v, err
+}
+
+// Feed returns the events of feed, e.g. "takeover", that happened after after. The feed
+// lists the newest events first.
+func (c *Client) Feed(ctx context.Context, feed string, after time.Time) ([]FeedItem, error) {
+	var v []FeedItem
+	u := c.FeedURL(feed) + "?afterDate=" + url.QueryEscape(after.UTC().Format(timeLayout))
+	err := c.do(ctx, http.MethodGet, u, nil, &v)
+	return v, err
+}
+
+// FeedURL returns the URL of feed, without the query added by Feed.
+func (c *Client) FeedURL(feed string) string {
+	return strings.TrimSuffix(c.Endpoints.Feeds, "/") + "/" + feed
+}
+
+// ErrResponseTooLarge is returned if a response is larger than Client.MaxResponseBytes.
+var ErrResponseTooLarge = errors.New("response from the Turf API is too large")
+
+// StatusError is returned when the API answers with a status other than 2xx.
+type StatusError struct {
+	StatusCode int
+	// Message is the error message in the response, if any.
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("unexpected status %d %s from the Turf API: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	}
+	return fmt.Sprintf("unexpected status %d %s from the Turf API", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// errorMessage returns the message of an error response, given as JSON object with an
+// errorMessage, error or message field, or "" if there is none.
+func errorMessage(b []byte) string {
+	var v struct {
+		ErrorMessage string `json:"errorMessage"`
+		Error        string `json:"error"`
+		Message      string `json:"message"`
+	}
+	if json.Unmarshal(b, &v) != nil {
+		return ""
+	}
+	return strings.TrimSpace(cmp.Or(v.ErrorMessage, v.Error, v.Message))
+}
+
+// ErrNotModified is returned by conditional requests if the response didn't change since
+// the previous request.
+var ErrNotModified = errors.New("not modified")
+
+type conditionalKey struct{}
+
+// WithConditional returns a copy of ctx that makes GET requests conditional: they send the
+// ETag and Last-Modified of the previous response to the same URL, if any, and return
+// ErrNotModified instead of decoding the response if it didn't change.
+func WithConditional(ctx context.Context) context.Context {
+	return context.WithValue(ctx, conditionalKey{}, true)
+}
+
+func (c *Client) validator(url string) http.Header {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.validators[url]
+}
+
+func (c *Client) setValidator(url string, resp *http.Response) {
+	h := make(http.Header)
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		h.Set("If-None-Match", etag)
+	}
+	if modified := resp.Header.Get("Last-Modified"); modified != "" {
+		h.Set("If-Modified-Since", modified)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.validators == nil {
+		c.validators = make(map[string]http.Header)
+	}
+	c.validators[url] = h
+}
+
+// do sends body (if any) as JSON to url and decodes the response into v.
+func (c *Client) do(ctx context.Context, method string, url string, body any, v any) (err error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var (
+		reqBody io.Reader
+		payload []byte
+	)
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = b
+		if c.CompressRequests {
+			if b, err = compress(b); err != nil {
+				return err
+			}
+		}
+		reqBody = bytes.NewBuffer(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+		if c.CompressRequests {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+	}
+	for name, values := range c.Header {
+		req.Header[name] = values
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	conditional := method == http.MethodGet && ctx.Value(conditionalKey{}) != nil
+	if conditional {
+		for name, values := range c.validator(url) {
+			req.Header[name] = values
+		}
+	}
+	if c.Prepare != nil {
+		c.Prepare(req)
+	}
+
+	var (
+		resp     *http.Response
+		duration time.Duration
+	)
+	if c.Observe != nil {
+		defer func() { c.Observe(req, resp, duration, err) }()
+	}
+	respBody := limitedBuffer{max: failedBodyBytes}
+	if c.Failed != nil {
+		defer func() {
+			if err != nil && !errors.Is(err, ErrNotModified) {
+				c.Failed(req, payload, respBody.b, err)
+			}
+		}()
+	}
+
+	start := time.Now()
+	resp, err = c.HTTPClient.Do(req)
+	duration = time.Since(start)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		return ErrNotModified
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		respBody.Write(b)
+		return &StatusError{StatusCode: resp.StatusCode, Message: errorMessage(b)}
+	}
+
+	var rd io.Reader = resp.Body
+	if c.Failed != nil {
+		rd = io.TeeReader(resp.Body, &respBody)
+	}
+	r := &maxBytesReader{r: rd, max: c.MaxResponseBytes}
+	err = decodeJSON(r, v)
+	// The decoder doesn't always pass on errors of the reader, e.g. while skipping whitespace.
+	if r.exceeded() {
+		return ErrResponseTooLarge
+	}
+	if err != nil {
+		return err
+	}
+	if conditional {
+		c.setValidator(url, resp)
+	}
+	return nil
+}
+
+// decodeJSON decodes the JSON value read from r into v. Arrays decoded into slices are read
+// element by element, so that only a single element is buffered at a time.
+func decodeJSON(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	slice := reflect.ValueOf(v).Elem()
+	if slice.Kind() != reflect.Slice {
+		return dec.Decode(v)
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		slice.SetZero()
+		return nil
+	}
+	if tok != json.Delim('[') {
+		return &json.UnmarshalTypeError{Value: fmt.Sprint(tok), Type: slice.Type()}
+	}
+
+	for dec.More() {
+		elem := reflect.New(slice.Type().Elem())
+		if err := dec.Decode(elem.Interface()); err != nil {
+			return err
+		}
+		slice.Set(reflect.Append(slice, elem.Elem()))
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// failedBodyBytes is the size of the start of the response bodies passed to Client.Failed.
+const failedBodyBytes = 1 << 20
+
+// limitedBuffer keeps the first max bytes written to it.
+type limitedBuffer struct {
+	b   []byte
+	max int
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	l.b = append(l.b, p[:min(len(p), l.max-len(l.b))]...)
+	return len(p), nil
+}
+
+// maxBytesReader reads from r and fails with ErrResponseTooLarge once more than max bytes
+// were read, unless max is 0.
+type maxBytesReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.read += int64(n)
+	if m.exceeded() {
+		return n, ErrResponseTooLarge
+	}
+	return n, err
+}
+
+func (m *maxBytesReader) exceeded() bool {
+	return m.max > 0 && m.read > m.max
+}
+
+// compress returns b gzipped.
+func compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RateLimitError is returned when the API answers 429 Too Many Requests.
+type RateLimitError struct {
+	// RetryAfter is how long the API asked to wait, or 0 if it didn't say.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited by the Turf API, retry after %v", e.RetryAfter)
+	}
+	return "rate limited by the Turf API"
+}
+
+// parseRetryAfter parses a Retry-After header, given either in seconds or as an HTTP date.
+func parseRetryAfter(s string, now time.Time) time.Duration {
+	if secs, err := strconv.Atoi(s); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(s); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package turf
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []int
+		wantErr bool
+	}{
+		{name: "array", body: `[1, 2, 3]`, want: []int{1, 2, 3}},
+		{name: "empty", body: `[]`, want: nil},
+		{name: "null", body: `null`, want: nil},
+		{name: "object", body: `{"a": 1}`, wantErr: true},
+		{name: "wrong type", body: `[1, "two"]`, wantErr: true},
+		{name: "cut off", body: `[1, 2`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			err := decodeJSON(strings.NewReader(tt.body), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	var got []int
+	if err := decodeJSON(strings.NewReader(`{"a": 1}`), &got); !errors.As(err, &typeErr) {
+		t.Errorf("got %v for an object, want a json.UnmarshalTypeError", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{"-5", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"errorMessage": " Unknown user "}`, "Unknown user"},
+		{`{"error": "Bad request"}`, "Bad request"},
+		{`{"message": "Slow down"}`, "Slow down"},
+		{`{"errorMessage": "first", "message": "second"}`, "first"},
+		{`<html>Bad gateway</html>`, ""},
+		{``, ""},
+	}
+	for _, tt := range tests {
+		if got := errorMessage([]byte(tt.body)); got != tt.want {
+			t.Errorf("errorMessage(%s) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package turf_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+	"github.com/dhose/go-turfgame-exporter/pkg/turf/turftest"
+)
+
+func TestClientUsers(t *testing.T) {
+	srv := turftest.NewServer(turftest.Demo())
+	defer srv.Close()
+	client := turf.NewClient(turftest.Endpoints(srv.URL))
+
+	users, err := client.Users(context.Background(), []turf.UserRef{{Name: "alice"}, {Name: "Nobody"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != "Alice" {
+		t.Fatalf("got %+v, want only Alice", users)
+	}
+	if users[0].Points == 0 || len(users[0].Zones) == 0 {
+		t.Errorf("Alice decoded without points or zones: %+v", users[0])
+	}
+}
+
+func TestClientFeed(t *testing.T) {
+	start := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+	srv := turftest.NewServer(&turftest.Fixtures{Feeds: map[string][]turf.FeedItem{
+		"takeover": {
+			{Type: "takeover", Time: turf.Time{Time: start}},
+			{Type: "takeover", Time: turf.Time{Time: start.Add(time.Minute)}},
+			{Type: "takeover", Time: turf.Time{Time: start.Add(2 * time.Minute)}},
+		},
+	}})
+	defer srv.Close()
+	client := turf.NewClient(turftest.Endpoints(srv.URL))
+
+	items, err := client.Feed(context.Background(), "takeover", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d takeovers, want the 2 after %v", len(items), start)
+	}
+	if !items[0].Time.Equal(start.Add(2 * time.Minute)) {
+		t.Errorf("got %v first, want the newest", items[0].Time.Time)
+	}
+}
+
+// newServer serves handler as the users endpoint and returns a client for it.
+func newServer(t *testing.T, handler http.HandlerFunc) *turf.Client {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return turf.NewClient(turf.Endpoints{Users: srv.URL + "/users"})
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		check   func(t *testing.T, err error)
+	}{
+		{
+			name: "status with message",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errorMessage": " Unknown user "}`))
+			},
+			check: func(t *testing.T, err error) {
+				var statusErr *turf.StatusError
+				if !errors.As(err, &statusErr) {
+					t.Fatalf("got %v, want a StatusError", err)
+				}
+				if statusErr.StatusCode != http.StatusBadRequest || statusErr.Message != "Unknown user" {
+					t.Errorf("got %+v", statusErr)
+				}
+			},
+		},
+		{
+			name: "status without message",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "<html>down</html>", http.StatusBadGateway)
+			},
+			check: func(t *testing.T, err error) {
+				var statusErr *turf.StatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadGateway || statusErr.Message != "" {
+					t.Errorf("got %v, want a StatusError 502 without message", err)
+				}
+			},
+		},
+		{
+			name: "rate limited",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			check: func(t *testing.T, err error) {
+				var rl *turf.RateLimitError
+				if !errors.As(err, &rl) || rl.RetryAfter != 30*time.Second {
+					t.Errorf("got %v, want a RateLimitError to retry after 30s", err)
+				}
+			},
+		},
+		{
+			name: "rate limited until a date",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			check: func(t *testing.T, err error) {
+				var rl *turf.RateLimitError
+				if !errors.As(err, &rl) || rl.RetryAfter < 59*time.Minute || rl.RetryAfter > time.Hour {
+					t.Errorf("got %v, want a RateLimitError to retry after about an hour", err)
+				}
+			},
+		},
+		{
+			name: "not JSON",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("<html>oops</html>"))
+			},
+			check: func(t *testing.T, err error) {
+				if err == nil {
+					t.Error("got no error")
+				}
+			},
+		},
+		{
+			name: "object instead of array",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"name": "Alice"}`))
+			},
+			check: func(t *testing.T, err error) {
+				if err == nil {
+					t.Error("got no error")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newServer(t, tt.handler).Users(context.Background(), []turf.UserRef{{Name: "Alice"}})
+			tt.check(t, err)
+		})
+	}
+}
+
+func TestClientNull(t *testing.T) {
+	client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("null"))
+	})
+	users, err := client.Users(context.Background(), []turf.UserRef{{Name: "Alice"}})
+	if err != nil || users != nil {
+		t.Errorf("got %v, %v, want no users and no error", users, err)
+	}
+}
+
+func TestClientMaxResponseBytes(t *testing.T) {
+	client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[" + strings.Repeat(" ", 2048) + "]"))
+	})
+	client.MaxResponseBytes = 1024
+
+	if _, err := client.Users(context.Background(), []turf.UserRef{{Name: "Alice"}}); !errors.Is(err, turf.ErrResponseTooLarge) {
+		t.Errorf("got %v, want ErrResponseTooLarge", err)
+	}
+
+	client.MaxResponseBytes = 4096
+	if _, err := client.Users(context.Background(), []turf.UserRef{{Name: "Alice"}}); err != nil {
+		t.Errorf("got %v below the limit", err)
+	}
+}
+
+func TestClientFailed(t *testing.T) {
+	client := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"errorMessage": "Unknown user"}`))
+	})
+	var gotReq, gotResp string
+	client.Failed = func(req *http.Request, reqBody []byte, respBody []byte, err error) {
+		gotReq, gotResp = string(reqBody), string(respBody)
+	}
+
+	client.Users(context.Background(), []turf.UserRef{{Name: "Alice"}})
+	if gotReq != `[{"name":"Alice"}]` || gotResp != `{"errorMessage": "Unknown user"}` {
+		t.Errorf("got request %s and response %s", gotReq, gotResp)
+	}
+}
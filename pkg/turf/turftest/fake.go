@@ -0,0 +1,130 @@
+// Package turftest provides a fake Turf API backed by fixtures, both as a client and as
+// an HTTP server.
+package turftest
+
+import (
+	"cmp"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+)
+
+// Fixtures is the data served by the fake API.
+type Fixtures struct {
+	Users      []turf.User     `json:"users"`
+	Zones      []turf.Zone     `json:"zones"`
+	Rounds     []turf.Round    `json:"rounds"`
+	Regions    []turf.Region   `json:"regions"`
+	Statistics turf.Statistics `json:"statistics"`
+	// Feeds maps feed names, e.g. "takeover", to their events.
+	Feeds map[string][]turf.FeedItem `json:"feeds"`
+}
+
+//go:embed demo.json
+var demoJSON []byte
+
+// Demo returns a small, made up game.
+func Demo() *Fixtures {
+	var f Fixtures
+	if err := json.Unmarshal(demoJSON, &f); err != nil {
+		panic(err)
+	}
+	return &f
+}
+
+// Fake answers the requests of a turf.Client from fixtures.
+type Fake struct {
+	Fixtures *Fixtures
+}
+
+func NewFake(f *Fixtures) *Fake {
+	return &Fake{Fixtures: f}
+}
+
+// Users returns the fixture users referred to by users, in the order of the fixtures.
+func (f *Fake) Users(ctx context.Context, users []turf.UserRef) ([]turf.User, error) {
+	var v []turf.User
+	for _, u := range f.Fixtures.Users {
+		if slices.ContainsFunc(users, func(r turf.UserRef) bool {
+			return r.Id == u.Id || (r.Id == 0 && strings.EqualFold(r.Name, u.Name))
+		}) {
+			v = append(v, u)
+		}
+	}
+	return v, nil
+}
+
+// Zones returns the fixture zones referred to by zones, in the order of the fixtures.
+func (f *Fake) Zones(ctx context.Context, zones []turf.ZoneRef) ([]turf.Zone, error) {
+	var v []turf.Zone
+	for _, z := range f.Fixtures.Zones {
+		if slices.ContainsFunc(zones, func(r turf.ZoneRef) bool {
+			return r.Id == z.Id || (r.Id == 0 && strings.EqualFold(r.Name, z.Name))
+		}) {
+			v = append(v, z)
+		}
+	}
+	return v, nil
+}
+
+func (f *Fake) AllZones(ctx context.Context) ([]turf.Zone, error) {
+	return f.Fixtures.Zones, nil
+}
+
+func (f *Fake) Rounds(ctx context.Context) ([]turf.Round, error) {
+	return f.Fixtures.Rounds, nil
+}
+
+func (f *Fake) Regions(ctx context.Context) ([]turf.Region, error) {
+	return f.Fixtures.Regions, nil
+}
+
+// Toplist ranks the fixture users of scope by their points.
+func (f *Fake) Toplist(ctx context.Context, scope string, limit int) ([]turf.User, error) {
+	kind, value, err := turf.ParseScope(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	var v []turf.User
+	for _, u := range f.Fixtures.Users {
+		switch {
+		case kind == "country" && !strings.EqualFold(u.Country, value):
+		case kind == "region" && strconv.Itoa(u.Region.Id) != value:
+		default:
+			v = append(v, u)
+		}
+	}
+
+	slices.SortStableFunc(v, func(a, b turf.User) int { return cmp.Compare(b.Points, a.Points) })
+	if len(v) > limit {
+		v = v[:limit]
+	}
+	for i := range v {
+		v[i].Place = i + 1
+	}
+	return v, nil
+}
+
+func (f *Fake) Statistics(ctx context.Context) (turf.Statistics, error) {
+	return f.Fixtures.Statistics, nil
+}
+
+// Feed returns the fixture events of feed after after, newest first like the API.
+func (f *Fake) Feed(ctx context.Context, feed string, after time.Time) ([]turf.FeedItem, error) {
+	var v []turf.FeedItem
+	for _, item := range f.Fixtures.Feeds[feed] {
+		if item.Time.After(after) {
+			v = append(v, item)
+		}
+	}
+
+	slices.SortStableFunc(v, func(a, b turf.FeedItem) int { return b.Time.Compare(a.Time.Time) })
+	return v, nil
+}
@@ -0,0 +1,122 @@
+package turftest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+)
+
+// NewServer starts a server that answers like the Turf API, from f. The caller should
+// Close it when done. See Endpoints for the URLs of its endpoints.
+func NewServer(f *Fixtures) *httptest.Server {
+	return httptest.NewServer(Handler(f))
+}
+
+// Handler returns a handler that answers like the Turf API, from f.
+func Handler(f *Fixtures) http.Handler {
+	fake := NewFake(f)
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /users", func(w http.ResponseWriter, r *http.Request) {
+		var refs []turf.UserRef
+		if !decode(w, r, &refs) {
+			return
+		}
+		users, _ := fake.Users(r.Context(), refs)
+		encode(w, users)
+	})
+
+	mux.HandleFunc("POST /zones", func(w http.ResponseWriter, r *http.Request) {
+		var refs []turf.ZoneRef
+		if !decode(w, r, &refs) {
+			return
+		}
+		zones, _ := fake.Zones(r.Context(), refs)
+		encode(w, zones)
+	})
+
+	mux.HandleFunc("GET /zones/all", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, f.Zones)
+	})
+
+	mux.HandleFunc("GET /rounds", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, f.Rounds)
+	})
+
+	mux.HandleFunc("GET /regions", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, f.Regions)
+	})
+
+	mux.HandleFunc("GET /users/top", func(w http.ResponseWriter, r *http.Request) {
+		scope := "global"
+		if country := r.URL.Query().Get("country"); country != "" {
+			scope = "country/" + country
+		} else if region := r.URL.Query().Get("region"); region != "" {
+			scope = "region/" + region
+		}
+
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil {
+			limit = 20
+		}
+
+		users, err := fake.Toplist(r.Context(), scope, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		encode(w, users)
+	})
+
+	mux.HandleFunc("GET /statistics", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, f.Statistics)
+	})
+
+	mux.HandleFunc("GET /feeds/{feed}", func(w http.ResponseWriter, r *http.Request) {
+		var after time.Time
+		if s := r.URL.Query().Get("afterDate"); s != "" {
+			var err error
+			if after, err = turf.ParseTime(s); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		items, _ := fake.Feed(r.Context(), r.PathValue("feed"), after)
+		encode(w, items)
+	})
+
+	return mux
+}
+
+// Endpoints returns the endpoints of a server started by NewServer at url.
+func Endpoints(url string) turf.Endpoints {
+	url = strings.TrimSuffix(url, "/")
+	return turf.Endpoints{
+		Users:      url + "/users",
+		Zones:      url + "/zones",
+		Rounds:     url + "/rounds",
+		Regions:    url + "/regions",
+		Toplist:    url + "/users/top",
+		Statistics: url + "/statistics",
+		Feeds:      url + "/feeds",
+	}
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func encode(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
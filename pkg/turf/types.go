@@ -0,0 +1,142 @@
+package turf
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// User is a player as returned by the users and toplist endpoints.
+type User struct {
+	Country          string `json:"country"`
+	Medals           []int  `json:"medals"`
+	Zones            []int  `json:"zones"`
+	PointsPerHour    int    `json:"pointsPerHour"`
+	Points           int    `json:"points"`
+	Blocktime        int    `json:"blocktime"`
+	Taken            int    `json:"taken"`
+	Name             string `json:"name"`
+	TotalPoints      int    `json:"totalPoints"`
+	Rank             int    `json:"rank"`
+	Id               int    `json:"id"`
+	Place            int    `json:"place"`
+	UniqueZonesTaken int    `json:"uniqueZonesTaken"`
+	Region           Region `json:"region"`
+}
+
+type Region struct {
+	Name       string `json:"name"`
+	Id         int    `json:"id"`
+	Country    string `json:"country"`
+	Area       Area   `json:"area"`
+	ZoneCount  int    `json:"zoneCount"`
+	RegionLord Owner  `json:"regionLord"`
+}
+
+type Area struct {
+	Name string `json:"name"`
+	Id   int    `json:"id"`
+}
+
+type Zone struct {
+	Name           string  `json:"name"`
+	Id             int     `json:"id"`
+	Region         Region  `json:"region"`
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	DateCreated    Time    `json:"dateCreated"`
+	DateLastTaken  Time    `json:"dateLastTaken"`
+	TakeoverPoints int     `json:"takeoverPoints"`
+	PointsPerHour  int     `json:"pointsPerHour"`
+	TotalTakeovers int     `json:"totalTakeovers"`
+	CurrentOwner   Owner   `json:"currentOwner"`
+	PreviousOwner  Owner   `json:"previousOwner"`
+}
+
+type Owner struct {
+	Name string `json:"name"`
+	Id   int    `json:"id"`
+}
+
+type Round struct {
+	Name  string `json:"name"`
+	Start Time   `json:"start"`
+}
+
+type Statistics struct {
+	TotalZones      int `json:"totalZones"`
+	TotalUsers      int `json:"totalUsers"`
+	ZonesTakenToday int `json:"zonesTakenToday"`
+}
+
+// FeedItem is an event from one of the Turf feeds. Which fields are set depends on Type.
+type FeedItem struct {
+	Type          string  `json:"type"`
+	Time          Time    `json:"time"`
+	Zone          Zone    `json:"zone"`
+	CurrentOwner  Owner   `json:"currentOwner"`
+	PreviousOwner Owner   `json:"previousOwner"`
+	Assists       []Owner `json:"assists"`
+	User          Owner   `json:"user"`
+	Medal         int     `json:"medal"`
+	Region        Region  `json:"region"`
+	Sender        Owner   `json:"sender"`
+}
+
+// Taker returns the user who made a takeover.
+func (f FeedItem) Taker() Owner {
+	if f.CurrentOwner.Name != "" {
+		return f.CurrentOwner
+	}
+	return f.Zone.CurrentOwner
+}
+
+// Loser returns the user who owned the zone before a takeover, if any.
+func (f FeedItem) Loser() Owner {
+	if f.PreviousOwner.Name != "" {
+		return f.PreviousOwner
+	}
+	return f.Zone.PreviousOwner
+}
+
+// Time handles the timestamp format used by the Turf API, e.g. "2013-08-24T12:29:29+0000".
+type Time struct {
+	time.Time
+}
+
+const timeLayout = "2006-01-02T15:04:05-0700"
+
+func (t *Time) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	if s == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+
+	parsed, err := ParseTime(s)
+	if err != nil {
+		return err
+	}
+
+	t.Time = parsed
+	return nil
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return json.Marshal("")
+	}
+	return json.Marshal(t.Format(timeLayout))
+}
+
+// ParseTime parses a timestamp in the format used by the Turf API, or in RFC 3339.
+func ParseTime(s string) (time.Time, error) {
+	t, err := time.Parse(timeLayout, s)
+	if err != nil {
+		return time.Parse(time.RFC3339, s)
+	}
+	return t, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+var throttledRequests = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "turfgame_api_throttled_requests_total",
+		Help: "Number of requests to Turfgame API that were delayed by the rate limiter",
+	},
+)
+
+// limitedTransport delays requests so that all requests to the API together stay within
+// the rate of limiter.
+type limitedTransport struct {
+	next    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+func (t limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := t.limiter.Reserve()
+	if delay := r.Delay(); delay > 0 {
+		throttledRequests.Inc()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			r.Cancel()
+			return nil, req.Context().Err()
+		}
+	}
+
+	return t.next.RoundTrip(req)
+}
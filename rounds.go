@@ -0,0 +1,81 @@
+package main
+
+import (
+	"time"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+)
+
+// roundTracker detects when a new round starts, either from the rounds endpoint or,
+// when that isn't polled, from the points of the watched users being reset.
+type roundTracker struct {
+	name   string
+	number int
+	points map[string]int
+}
+
+func newRoundTracker() *roundTracker {
+	return &roundTracker{points: make(map[string]int)}
+}
+
+// ObserveRound records the current round and reports whether it differs from the previous one.
+func (t *roundTracker) ObserveRound(name string, number int) bool {
+	changed := t.name != name || t.number != number
+	t.name = name
+	t.number = number
+	return changed
+}
+
+// ObservePoints records the users points and reports whether they were reset since the
+// previous poll. Points only ever grow during a round, so any decrease means a new round.
+func (t *roundTracker) ObservePoints(users []turf.User) bool {
+	reset := false
+	for _, user := range users {
+		if prev, ok := t.points[user.Name]; ok && user.Points < prev {
+			reset = true
+		}
+		t.points[user.Name] = user.Points
+	}
+	return reset
+}
+
+// updateRoundMetrics exports the round running at now and returns its name and number.
+// The API lists all rounds in order, so the position of a round in the list is used as its
+// sequence number and the start of the following round as its end. If the next round isn't
+// listed yet, its start is estimated.
+func updateRoundMetrics(rounds []turf.Round, now time.Time) (string, int, bool) {
+	for i, r := range rounds {
+		if r.Start.After(now) {
+			continue
+		}
+		if i+1 < len(rounds) && !rounds[i+1].Start.After(now) {
+			continue
+		}
+
+		roundId.Reset()
+		roundStart.Reset()
+		roundEnd.Reset()
+
+		roundId.WithLabelValues(r.Name).Set(float64(i + 1))
+		roundStart.WithLabelValues(r.Name).Set(float64(r.Start.Unix()))
+		end := nextRoundStart(r.Start.Time)
+		if i+1 < len(rounds) {
+			end = rounds[i+1].Start.Time
+		}
+		roundEnd.WithLabelValues(r.Name).Set(float64(end.Unix()))
+		currentRoundEnd.Store(end.Unix())
+		return r.Name, i + 1, true
+	}
+
+	return "", 0, false
+}
+
+// nextRoundStart estimates when the round following one started at start begins.
+// Rounds start on the first Sunday of each month at the same time of day.
+func nextRoundStart(start time.Time) time.Time {
+	next := time.Date(start.Year(), start.Month()+1, 1, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	for next.Weekday() != time.Sunday {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
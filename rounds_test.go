@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextRoundStart(t *testing.T) {
+	tests := []struct {
+		start time.Time
+		want  time.Time
+	}{
+		// June 2024 starts on a Saturday.
+		{time.Date(2024, 5, 5, 12, 0, 0, 0, time.UTC), time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)},
+		// September 2024 starts on a Sunday.
+		{time.Date(2024, 8, 4, 12, 0, 0, 0, time.UTC), time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)},
+		// Across the new year, keeping the time of day.
+		{time.Date(2024, 12, 1, 10, 30, 0, 0, time.UTC), time.Date(2025, 1, 5, 10, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := nextRoundStart(tt.start); !got.Equal(tt.want) {
+			t.Errorf("nextRoundStart(%v) = %v, want %v", tt.start, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var usersPollInterval = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "turfgame_users_poll_interval_seconds",
+		Help: "Current time between polls of the users with ADAPTIVE_POLLING_ENABLED",
+	},
+)
+
+// schedule decides the time between the polls of an endpoint.
+type schedule interface {
+	Interval() time.Duration
+	// Changed receives when Interval may have become shorter.
+	Changed() <-chan struct{}
+}
+
+// fixedSchedule polls at a fixed interval.
+type fixedSchedule time.Duration
+
+func (s fixedSchedule) Interval() time.Duration { return time.Duration(s) }
+
+func (s fixedSchedule) Changed() <-chan struct{} { return nil }
+
+// adaptiveSchedule polls more often while the users are active. The interval is the time
+// since the latest activity, kept between min and max, so a takeover shortens it to min
+// and it grows back while the users are idle.
+type adaptiveSchedule struct {
+	min, max time.Duration
+	// latest is the time of the latest activity in Unix nanoseconds, 0 if unknown.
+	latest  atomic.Int64
+	changed chan struct{}
+}
+
+func newAdaptiveSchedule(min, max time.Duration) *adaptiveSchedule {
+	return &adaptiveSchedule{min: min, max: max, changed: make(chan struct{}, 1)}
+}
+
+// Active records activity at t, unless newer activity is already known.
+func (s *adaptiveSchedule) Active(t time.Time) {
+	for {
+		latest := s.latest.Load()
+		if t.UnixNano() <= latest {
+			return
+		}
+		if s.latest.CompareAndSwap(latest, t.UnixNano()) {
+			break
+		}
+	}
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (s *adaptiveSchedule) Interval() time.Duration {
+	interval := s.max
+	if latest := s.latest.Load(); latest != 0 {
+		interval = min(max(time.Since(time.Unix(0, latest)), s.min), s.max)
+	}
+	usersPollInterval.Set(interval.Seconds())
+	return interval
+}
+
+func (s *adaptiveSchedule) Changed() <-chan struct{} { return s.changed }
+
+// waitNext waits until the next poll of s is due after a poll that returned err and
+// reports whether it did so without ctx being cancelled.
+func waitNext(ctx context.Context, s schedule, err error) bool {
+	start := time.Now()
+	for {
+		d := pollDelay(s.Interval(), err) - time.Since(start)
+		if d <= 0 {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(d):
+			return true
+		case <-s.Changed():
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"expvar"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/exporter-toolkit/web"
+	"gopkg.in/yaml.v3"
+)
+
+// listen returns the listener of the HTTP server on WEB_LISTEN_ADDRESS, or else on HTTPD_PORT
+// on all interfaces. WEB_LISTEN_ADDRESS unix:<path> listens on a unix socket at path. If
+// systemd passed a socket, that one is used instead.
+func (c Config) listen() (net.Listener, error) {
+	if l, err := systemdListener(); l != nil || err != nil {
+		return l, err
+	}
+
+	if c.ListenAddress == "" {
+		return net.Listen("tcp", ":"+c.HttpPort)
+	}
+
+	path, ok := strings.CutPrefix(c.ListenAddress, "unix:")
+	if !ok {
+		if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+			return nil, fmt.Errorf("invalid WEB_LISTEN_ADDRESS: %w", err)
+		}
+		return net.Listen("tcp", c.ListenAddress)
+	}
+
+	// The socket is left behind if the exporter didn't shut down gracefully.
+	if fi, err := os.Stat(path); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if c.SocketMode != "" {
+		mode, err := strconv.ParseUint(c.SocketMode, 8, 32)
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("invalid WEB_SOCKET_MODE %q, expected an octal mode like 0660", c.SocketMode)
+		}
+		if err := os.Chmod(path, fs.FileMode(mode)); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// serve serves server on l, with the TLS and basic auth of WEB_CONFIG_FILE if set. The file
+// is read again for each new connection, so certificates can be renewed without a restart.
+func (c Config) serve(server *http.Server, l net.Listener) error {
+	if c.WebConfigFile == "" {
+		return server.Serve(l)
+	}
+	logger := kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr))
+	if c.LogFormat == "json" {
+		logger = kitlog.NewJSONLogger(kitlog.NewSyncWriter(os.Stderr))
+	}
+	return web.Serve(l, server, &web.FlagConfig{WebConfigFile: &c.WebConfigFile}, logger)
+}
+
+// reservedPaths are the paths of the exporter's own endpoints, which the metrics can't be
+// served on. Those ending in / include the paths below them.
+var reservedPaths = []string{"/", "/geojson", "/healthz", "/readyz", "/-/", "/api/", "/debug/"}
+
+// checkTelemetryPath returns an error if the metrics can't be served on path, because it
+// isn't absolute or is one of reservedPaths.
+func checkTelemetryPath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return errors.New("WEB_TELEMETRY_PATH must start with /")
+	}
+	for _, reserved := range reservedPaths {
+		subtree := reserved != "/" && strings.HasSuffix(reserved, "/")
+		if strings.TrimSuffix(path, "/") == strings.TrimSuffix(reserved, "/") || subtree && strings.HasPrefix(path, reserved) {
+			return fmt.Errorf("WEB_TELEMETRY_PATH can't be %s, which is used by the exporter", reserved)
+		}
+	}
+	return nil
+}
+
+// basicAuth reports whether the web configuration file at path, if any, requires basic auth.
+func basicAuth(path string) (bool, error) {
+	if path == "" {
+		return false, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	var v struct {
+		Users map[string]string `yaml:"basic_auth_users"`
+	}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return false, err
+	}
+	return len(v.Users) > 0, nil
+}
+
+// servePlain serves handler over plain HTTP on address, the value of the setting env such as
+// WEB_ADMIN_LISTEN_ADDRESS, until the returned server is shut down. It has no write timeout,
+// as the profiles of /debug/pprof/ take as long as requested.
+func (c Config) servePlain(env string, address string, handler http.Handler) (*http.Server, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", env, err)
+	}
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{
+		Handler:        handler,
+		ReadTimeout:    time.Duration(c.ReadTimeoutSec) * time.Second,
+		IdleTimeout:    time.Duration(c.IdleTimeoutSec) * time.Second,
+		MaxHeaderBytes: c.MaxHeaderBytes,
+	}
+	go func() {
+		if err := server.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			fatal(err.Error())
+		}
+	}()
+	return server, nil
+}
+
+// healthzHandler answers 200 while the polls are being handled and 503 once they have stalled
+// for longer than max, e.g. because of a deadlock, so that the exporter gets restarted.
+func healthzHandler(e *exporter, max time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d, stalled := e.Stalled(max); stalled {
+			http.Error(w, fmt.Sprintf("Polling stalled for %s", d.Truncate(time.Second)), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK\n"))
+	})
+}
+
+// readyzHandler answers 503 until the users are first fetched and while they were last
+// fetched longer than maxAge ago, if set, and 200 otherwise.
+func readyzHandler(e *exporter, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		age, ok := e.DataAge()
+		switch {
+		case !ok:
+			http.Error(w, "Users not fetched yet", http.StatusServiceUnavailable)
+		case maxAge > 0 && age > maxAge:
+			http.Error(w, fmt.Sprintf("Users last fetched %s ago", age.Truncate(time.Second)), http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("OK\n"))
+		}
+	})
+}
+
+// landingPageTemplate is the page served on /.
+var landingPageTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Turfgame Exporter</title></head>
+<body>
+<h1>Turfgame Exporter</h1>
+<p>Prometheus exporter for turfgame.com user statistics, version {{.Version}}</p>
+<ul>
+{{- range .Links}}
+<li><a href="{{.Address}}">{{.Text}}</a></li>
+{{- end}}
+</ul>
+{{- if .Users}}
+<h2>Users</h2>
+<ul>
+{{- range .Users}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- end}}
+</body>
+</html>
+`))
+
+type landingLink struct {
+	Address, Text string
+}
+
+// landingPage returns the page served on /, with links to the other endpoints and, with
+// WEB_LANDING_PAGE_USERS_ENABLED, the users of TURF_USERS unless they are anonymized.
+func landingPage(c Config) (http.Handler, error) {
+	data := struct {
+		Version string
+		Links   []landingLink
+		Users   []string
+	}{
+		Version: exporterVersion(),
+		// Relative links keep working behind a reverse proxy serving the exporter on a sub-path.
+		Links: []landingLink{
+			{Address: strings.TrimPrefix(c.TelemetryPath, "/"), Text: "Metrics"},
+		},
+	}
+	// Otherwise they are served on WEB_ADMIN_LISTEN_ADDRESS.
+	if c.AdminAddress == "" {
+		data.Links = append(data.Links, landingLink{Address: "healthz", Text: "Liveness"}, landingLink{Address: "readyz", Text: "Readiness"})
+	}
+	if c.TurfUserZones {
+		data.Links = append(data.Links, landingLink{Address: "geojson", Text: "Zones owned by the users as GeoJSON"})
+	}
+	if c.LandingUsers && c.TurfAnonymizeUsers == "" {
+		data.Users = c.TurfUsers
+	}
+
+	var b bytes.Buffer
+	if err := landingPageTemplate.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(b.Bytes())
+	}), nil
+}
+
+// exporterVersion returns the version set at build time in
+// github.com/prometheus/common/version.Version, else the module version of the build.
+func exporterVersion() string {
+	if version.Version != "" {
+		return version.Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// debugHandler serves the Go profiling endpoints of net/http/pprof under /debug/pprof/, the
+// variables published with expvar on /debug/vars and the configuration c on /debug/config.
+func debugHandler(c Config) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /debug/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, effectiveConfig(c))
+	})
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBasicAuth(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		config string
+		want   bool
+	}{
+		{"basic_auth_users:\n  alice: $2y$10$abcdefghijklmnopqrstuu\n", true},
+		{"tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n", false},
+		{"basic_auth_users: {}\n", false},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, "web.yml")
+		if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := basicAuth(path); err != nil || got != tt.want {
+			t.Errorf("%d: got %v, %v, want %v", i, got, err, tt.want)
+		}
+	}
+
+	if got, err := basicAuth(""); err != nil || got {
+		t.Errorf("got %v, %v without WEB_CONFIG_FILE, want false", got, err)
+	}
+	if _, err := basicAuth(filepath.Join(dir, "missing.yml")); err == nil {
+		t.Error("got no error for a missing file")
+	}
+}
+
+func TestCheckTelemetryPath(t *testing.T) {
+	for _, path := range []string{"/metrics", "/turfgame/metrics", "/metrics/", "/healthzz"} {
+		if err := checkTelemetryPath(path); err != nil {
+			t.Errorf("checkTelemetryPath(%q) = %v, want no error", path, err)
+		}
+	}
+	for _, path := range []string{"metrics", "", "/", "/geojson", "/healthz", "/readyz/", "/-/poll", "/-", "/api/v1/users", "/debug/vars", "/debug"} {
+		if err := checkTelemetryPath(path); err == nil {
+			t.Errorf("checkTelemetryPath(%q) succeeded, want an error", path)
+		}
+	}
+}
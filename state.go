@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// state is the derived state kept in STATE_FILE, so that a restart of the exporter neither
+// resets the counters nor forgets the previously seen values they are derived from.
+type state struct {
+	Totals      counterTracker       `json:"totals"`
+	Taken       counterTracker       `json:"taken"`
+	Owned       zoneTracker          `json:"owned"`
+	Activity    map[string]time.Time `json:"activity"`
+	RoundName   string               `json:"round_name"`
+	RoundNumber int                  `json:"round_number"`
+	RoundPoints map[string]int       `json:"round_points"`
+	// FeedCursors holds the time of the latest event seen of each feed.
+	FeedCursors map[string]time.Time `json:"feed_cursors"`
+	// Users holds the watched users with PERSIST_USERS_ENABLED, as in TURF_USERS.
+	Users []string `json:"users,omitempty"`
+	// Countries holds the country of each user with TURF_COUNTRY_LABEL, keyed by lower-cased
+	// username, which the labels of the persisted counters depend on.
+	Countries map[string]string `json:"countries,omitempty"`
+	// Aliases holds the aliases of the users with TURF_ANONYMIZE_USERS=alias, keyed by
+	// lower-cased username, which are only numbered in the order the users are seen.
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// Counters holds the series of persistedCounters by metric name.
+	Counters map[string][]counterSample `json:"counters"`
+}
+
+type counterSample struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// persistedCounters returns the counters kept in STATE_FILE.
+func persistedCounters() []prometheus.Collector {
+	return []prometheus.Collector{
+		totalPointsCounter, takenZonesCounter, zonesGained, zonesLost, userTakeovers, userAssists,
+		userMedalEvents, userZonesLostEvents, chatMessages, roundChanges,
+	}
+}
+
+// loadState reads the state from path. A missing file is an empty state.
+func loadState(path string) (*state, error) {
+	s := &state{
+		Totals:      make(counterTracker),
+		Taken:       make(counterTracker),
+		Owned:       make(zoneTracker),
+		Activity:    make(map[string]time.Time),
+		RoundPoints: make(map[string]int),
+		FeedCursors: make(map[string]time.Time),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Restore restores the persisted counters and the activity of the users, which it returns.
+// Their series are labeled with the countries of the users if countryLabel is set, so
+// those are restored first.
+func (s *state) Restore(countryLabel bool) activityTracker {
+	if countryLabel {
+		for user, country := range s.Countries {
+			userLabels.Set(user, "country", country)
+		}
+	}
+	restoreCounters(persistedCounters(), s.Counters)
+
+	activity := make(activityTracker)
+	for user, t := range s.Activity {
+		activity.Observe(user, t)
+	}
+	return activity
+}
+
+// Save writes the state to path, replacing the previous state at once.
+func (s *state) Save(path string) error {
+	s.Counters = snapshotCounters(persistedCounters())
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// snapshotCounters returns the series of counters by metric name.
+func snapshotCounters(counters []prometheus.Collector) map[string][]counterSample {
+	samples := make(map[string][]counterSample)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		for _, c := range counters {
+			c.Collect(ch)
+		}
+		close(ch)
+	}()
+
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil || pb.Counter == nil {
+			continue
+		}
+		labels := make(map[string]string)
+		for _, l := range pb.Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		name := descName(m.Desc())
+		samples[name] = append(samples[name], counterSample{Labels: labels, Value: pb.Counter.GetValue()})
+	}
+	return samples
+}
+
+// restoreCounters adds the values of samples to counters. Series whose labels no longer
+// match the counter, e.g. after TURF_USER_LABELS changed, are skipped.
+func restoreCounters(counters []prometheus.Collector, samples map[string][]counterSample) {
+	for _, c := range counters {
+		for _, d := range describe(c) {
+			for _, sample := range samples[descName(d)] {
+				switch c := c.(type) {
+				case *prometheus.CounterVec:
+					if counter, err := c.GetMetricWith(sample.Labels); err == nil {
+						counter.Add(sample.Value)
+					}
+				case prometheus.Counter:
+					c.Add(sample.Value)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// setupCountryLabel sets up the per-user metrics as with TURF_COUNTRY_LABEL, like a new start
+// of the exporter.
+func setupCountryLabel(t *testing.T) {
+	prev := userLabels
+	t.Cleanup(func() {
+		userLabels = prev
+		newUserMetrics(false)
+	})
+
+	userLabels = extraUserLabels{}
+	userLabels.Add("country", nil)
+	newUserMetrics(false)
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.Counter.GetValue()
+}
+
+func TestStateCountryLabel(t *testing.T) {
+	c := Config{TurfCountryLabel: true, TurfFeeds: []string{"takeover"}}
+	users := []turf.User{{Name: "Alice", Country: "se", TotalPoints: 1000}}
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	setupCountryLabel(t)
+	initFeedCounters(c, "Alice")
+	updateCountryLabels(c, users)
+	totalPointsCounter.With(userLabels.For("Alice")).Add(1000)
+	userTakeovers.With(userLabels.For("Alice")).Add(3)
+	if n := userTakeovers.DeletePartialMatch(prometheus.Labels{"country": ""}); n != 0 {
+		t.Errorf("got %d takeover series without country, want those created before the poll removed", n)
+	}
+
+	st, err := loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Countries = userLabels.Values("country")
+	st.Activity = map[string]time.Time{"Alice": time.Unix(1717000000, 0)}
+	if err := st.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart and poll again.
+	setupCountryLabel(t)
+	if st, err = loadState(path); err != nil {
+		t.Fatal(err)
+	}
+	st.Activity = st.Restore(c.TurfCountryLabel)
+	initFeedCounters(c, "Alice")
+	updateCountryLabels(c, users)
+	for _, m := range userMetrics {
+		if n := m.DeletePartialMatch(prometheus.Labels{"country": ""}); n != 0 {
+			t.Errorf("got %d series without country after the restart, want none", n)
+		}
+	}
+
+	alice := prometheus.Labels{"user": "Alice", "country": "se"}
+	var activity dto.Metric
+	if err := userLastActivity.With(alice).Write(&activity); err != nil || activity.Gauge.GetValue() != 1717000000 {
+		t.Errorf("got last activity %v, %v after the restart, want 1717000000", activity.Gauge.GetValue(), err)
+	}
+	if got := counterValue(t, totalPointsCounter.With(alice)); got != 1000 {
+		t.Errorf("got total points %v after the restart, want 1000", got)
+	}
+	if got := counterValue(t, userTakeovers.With(alice)); got != 3 {
+		t.Errorf("got takeovers %v after the restart, want 3", got)
+	}
+
+	if err := st.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if st, err = loadState(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range st.Counters["turfgame_user_total_points_total"] {
+		if sample.Labels["user"] == "Alice" && (sample.Labels["country"] != "se" || sample.Value != 1000) {
+			t.Errorf("got %+v saved, want 1000 with country se", sample)
+		}
+	}
+
+	// A user who moved gets their series under the new country only.
+	updateCountryLabels(c, []turf.User{{Name: "Alice", Country: "fi"}})
+	if n := totalPointsCounter.DeletePartialMatch(alice); n != 0 {
+		t.Errorf("got %d series with the previous country, want none", n)
+	}
+}
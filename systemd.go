@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+)
+
+// systemdListener returns the first socket passed by systemd socket activation, see
+// sd_listen_fds(3), or nil if the exporter wasn't socket activated.
+func systemdListener() (net.Listener, error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if pid != os.Getpid() || fds < 1 {
+		return nil, nil
+	}
+	// Child processes must not take the sockets for theirs.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	// The passed sockets start at file descriptor 3.
+	f := os.NewFile(3, "LISTEN_FD_3")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket passed by systemd: %w", err)
+	}
+	slog.Info("Listening on the socket passed by systemd", "address", l.Addr())
+	return l, nil
+}
+
+// sdNotify sends state to systemd, see sd_notify(3). It does nothing unless the exporter
+// runs as a systemd service that expects notifications, e.g. with Type=notify.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		slog.Error("An Error Occured", "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Error("An Error Occured", "err", err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+)
+
+// teams groups users into named teams.
+type teams struct {
+	names []string
+	// members maps lower-cased usernames to their team.
+	members map[string]string
+}
+
+// parseTeams parses teams given as "alpha:user1,user2;beta:user3". A user can only
+// be member of one team.
+func parseTeams(s string) (teams, error) {
+	t := teams{members: make(map[string]string)}
+	if strings.TrimSpace(s) == "" {
+		return t, nil
+	}
+
+	for _, team := range strings.Split(s, ";") {
+		name, users, ok := strings.Cut(team, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return teams{}, fmt.Errorf("invalid team %q", team)
+		}
+
+		t.names = append(t.names, name)
+		for _, u := range strings.Split(users, ",") {
+			u = strings.ToLower(strings.TrimSpace(u))
+			if u == "" {
+				continue
+			}
+			if other, ok := t.members[u]; ok {
+				return teams{}, fmt.Errorf("user %q is member of both team %q and %q", u, other, name)
+			}
+			t.members[u] = name
+		}
+	}
+
+	return t, nil
+}
+
+func updateTeamMetrics(users []turf.User, t teams) {
+	points := make(map[string]int)
+	zones := make(map[string]int)
+	for _, user := range users {
+		if team, ok := t.members[strings.ToLower(user.Name)]; ok {
+			points[team] += user.Points
+			zones[team] += len(user.Zones)
+		}
+	}
+
+	for _, team := range t.names {
+		teamPoints.WithLabelValues(team).Set(float64(points[team]))
+		teamZonesOwned.WithLabelValues(team).Set(float64(zones[team]))
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestParseTeams(t *testing.T) {
+	tests := []struct {
+		in          string
+		wantNames   []string
+		wantMembers map[string]string
+		wantErr     bool
+	}{
+		{in: "", wantNames: nil, wantMembers: map[string]string{}},
+		{
+			in:          "alpha: Alice, bob ;beta:Carol,",
+			wantNames:   []string{"alpha", "beta"},
+			wantMembers: map[string]string{"alice": "alpha", "bob": "alpha", "carol": "beta"},
+		},
+		{in: "alpha:", wantNames: []string{"alpha"}, wantMembers: map[string]string{}},
+		{in: "alpha", wantErr: true},
+		{in: ":alice", wantErr: true},
+		{in: "alpha:alice;beta:Alice", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTeams(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTeams(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !slices.Equal(got.names, tt.wantNames) || !maps.Equal(got.members, tt.wantMembers) {
+			t.Errorf("parseTeams(%q) = %v %v, want %v %v", tt.in, got.names, got.members, tt.wantNames, tt.wantMembers)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// spanContext identifies a span of a W3C trace.
+type spanContext struct {
+	traceID string
+	spanID  string
+}
+
+type spanKey struct{}
+
+// withSpan returns a copy of ctx that carries sc.
+func withSpan(ctx context.Context, sc spanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+// spanFromContext returns the span carried by ctx, if any.
+func spanFromContext(ctx context.Context) (spanContext, bool) {
+	sc, ok := ctx.Value(spanKey{}).(spanContext)
+	return sc, ok
+}
+
+// parseTraceparent parses a W3C traceparent header, e.g.
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01". Only sampled traces are returned,
+// since the others aren't recorded by the tracing backend.
+func parseTraceparent(s string) (spanContext, bool) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || parts[0] != "00" || !validID(parts[1], 32) || !validID(parts[2], 16) {
+		return spanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 || flags[0]&1 == 0 {
+		return spanContext{}, false
+	}
+	return spanContext{traceID: parts[1], spanID: parts[2]}, true
+}
+
+// validID reports whether id is n lower-case hex digits, not all zero.
+func validID(id string, n int) bool {
+	if len(id) != n || strings.Trim(id, "0") == "" {
+		return false
+	}
+	return strings.Trim(id, "0123456789abcdef") == ""
+}
+
+// traceHandler adds the span of the traceparent header of requests, e.g. sent by Prometheus
+// with tracing configured, to their context, so that the API requests they trigger join
+// the trace.
+func traceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sc, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			r = r.WithContext(withSpan(r.Context(), sc))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// startTrace adds a W3C traceparent header to req for a new child of the span in its
+// context, so that the request can be found in the tracing backend of the API. Requests
+// without a span are left untraced.
+func startTrace(req *http.Request) {
+	sc, ok := spanFromContext(req.Context())
+	if !ok {
+		return
+	}
+	spanID := make([]byte, 8)
+	rand.Read(spanID)
+
+	req.Header.Set("traceparent", "00-"+sc.traceID+"-"+hex.EncodeToString(spanID)+"-01")
+}
+
+// traceExemplar returns the exemplar labels with the trace ID of the span of req, or nil
+// if req isn't traced.
+func traceExemplar(req *http.Request) prometheus.Labels {
+	sc, ok := spanFromContext(req.Context())
+	if !ok {
+		return nil
+	}
+	return prometheus.Labels{"trace_id": sc.traceID}
+}
+
+func addWithExemplar(c prometheus.Counter, v float64, exemplar prometheus.Labels) {
+	if exemplar == nil {
+		c.Add(v)
+		return
+	}
+	c.(prometheus.ExemplarAdder).AddWithExemplar(v, exemplar)
+}
+
+func observeWithExemplar(o prometheus.Observer, v float64, exemplar prometheus.Labels) {
+	if exemplar == nil {
+		o.Observe(v)
+		return
+	}
+	o.(prometheus.ExemplarObserver).ObserveWithExemplar(v, exemplar)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header string
+		want   spanContext
+		ok     bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", spanContext{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"}, true},
+		// Not sampled.
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", spanContext{}, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", spanContext{}, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", spanContext{}, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", spanContext{}, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", spanContext{}, false},
+		{"00-4bf92f3577b34da6-00f067aa0ba902b7-01", spanContext{}, false},
+		{"", spanContext{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTraceparent(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseTraceparent(%q) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestStartTrace(t *testing.T) {
+	untraced := httptest.NewRequest(http.MethodPost, "/users", nil)
+	startTrace(untraced)
+	if h := untraced.Header.Get("traceparent"); h != "" || traceExemplar(untraced) != nil {
+		t.Errorf("got traceparent %q for a request without a span, want none and no exemplar", h)
+	}
+
+	scrape := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	scrape.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	var ctx context.Context
+	traceHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	})).ServeHTTP(httptest.NewRecorder(), scrape)
+
+	req := httptest.NewRequest(http.MethodPost, "/users", nil).WithContext(ctx)
+	startTrace(req)
+	sc, ok := parseTraceparent(req.Header.Get("traceparent"))
+	if !ok || sc.traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.spanID == "00f067aa0ba902b7" {
+		t.Errorf("got traceparent %q, want a new span of the trace of the scrape", req.Header.Get("traceparent"))
+	}
+	if got := traceExemplar(req)["trace_id"]; got != sc.traceID {
+		t.Errorf("got exemplar trace_id %q, want %q", got, sc.traceID)
+	}
+}
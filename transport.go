@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var apiConnections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "turfgame_api_connections_total",
+		Help: "Number of connections used for requests to Turfgame API, by whether they were reused",
+	},
+	[]string{"reused"},
+)
+
+var apiRequestPhases = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "turfgame_api_request_phase_duration_seconds",
+		Help:    "Duration of the phases of requests to Turfgame API: dns, connect and tls for new connections, and first_byte from sending the request to the first byte of the response",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"phase"},
+)
+
+// newTransport returns the transport of the API client, with the idle connections,
+// keep-alives, proxy and TLS configured, that fails over to the other URLs of TURF_API_URL
+// if there are any. Without API_PROXY_URL the proxy is taken from the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func newTransport(c Config) (http.RoundTripper, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = c.ApiMaxIdleConns
+	t.MaxIdleConnsPerHost = c.ApiMaxIdleConns
+	t.IdleConnTimeout = time.Duration(c.ApiIdleTimeoutSec) * time.Second
+	t.DisableKeepAlives = !c.ApiKeepAlives
+
+	if c.ApiProxyUrl != "" {
+		proxy, err := url.Parse(c.ApiProxyUrl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API_PROXY_URL: %w", err)
+		}
+		if !slices.Contains([]string{"http", "https", "socks5", "socks5h"}, proxy.Scheme) {
+			return nil, fmt.Errorf("unsupported proxy scheme %q in API_PROXY_URL, expected http, https, socks5 or socks5h", proxy.Scheme)
+		}
+		if c.ApiProxyUser != "" {
+			proxy.User = url.UserPassword(c.ApiProxyUser, c.ApiProxyPassword)
+		}
+		t.Proxy = http.ProxyURL(proxy)
+	}
+
+	tlsConfig, err := newTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsConfig
+
+	var rt http.RoundTripper = connTransport{next: t}
+	if bases := c.apiBases(); len(bases) > 1 {
+		rt = newFailoverTransport(rt, bases)
+	}
+	return rt, nil
+}
+
+// newTLSConfig returns the TLS configuration of the API client. API_CA_FILE replaces the
+// system CAs, e.g. with the CA of a TLS-intercepting gateway.
+func newTLSConfig(c Config) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: c.ApiInsecure}
+
+	if c.ApiCaFile != "" {
+		pem, err := os.ReadFile(c.ApiCaFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in API_CA_FILE %s", c.ApiCaFile)
+		}
+	}
+
+	if c.ApiCertFile != "" || c.ApiKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ApiCertFile, c.ApiKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// connTransport counts whether requests reuse a connection and observes the duration of
+// the phases of the requests.
+type connTransport struct {
+	next http.RoundTripper
+}
+
+func (t connTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		// The hooks may be called from other goroutines, e.g. when dialing several addresses.
+		mu                                      sync.Mutex
+		dnsStart, connectStart, tlsStart, wrote time.Time
+	)
+	observe := func(phase string, start *time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !start.IsZero() {
+			apiRequestPhases.WithLabelValues(phase).Observe(time.Since(*start).Seconds())
+			*start = time.Time{}
+		}
+	}
+	begin := func(start *time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		*start = time.Now()
+	}
+
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { begin(&dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { observe("dns", &dnsStart) },
+		ConnectStart:         func(string, string) { begin(&connectStart) },
+		ConnectDone:          func(string, string, error) { observe("connect", &connectStart) },
+		TLSHandshakeStart:    func() { begin(&tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { observe("tls", &tlsStart) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { begin(&wrote) },
+		GotFirstResponseByte: func() { observe("first_byte", &wrote) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			apiConnections.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+		},
+	}
+	return t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
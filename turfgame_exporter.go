@@ -1,46 +1,146 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/sethvargo/go-envconfig"
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 type Config struct {
-	TurfApiEndpoint string   `env:"TURF_API_USERS_URL, default=https://api.turfgame.com/unstable/users"`
-	TurfUsers       []string `env:"TURF_USERS, required"`
-	PollIntervalSec int      `env:"POLL_INTERVAL_SEC, default=300"`
-	HttpPort        string   `env:"HTTPD_PORT, default=9097"`
-}
-
-type User struct {
-	Country          string `json:"country"`
-	Medals           []int  `json:"medals"`
-	Zones            []int  `json:"zones"`
-	PointsPerHour    int    `json:"pointsPerHour"`
-	Points           int    `json:"points"`
-	Blocktime        int    `json:"blocktime"`
-	Taken            int    `json:"taken"`
-	Name             string `json:"name"`
-	TotalPoints      int    `json:"totalPoints"`
-	Rank             int    `json:"rank"`
-	Id               int    `json:"id"`
-	Place            int    `json:"place"`
-	UniqueZonesTaken int    `json:"uniqueZonesTaken"`
-	Region           Region `json:"region"`
-}
-
-type Region struct {
-	Name string `json:"name"`
-	Id   int    `json:"id"`
+	TurfApiUrl           []string `env:"TURF_API_URL, default=https://api.turfgame.com"`
+	TurfApiVersion       string   `env:"TURF_API_VERSION, default=unstable"`
+	TurfApiEndpoint      string   `env:"TURF_API_USERS_URL"`
+	TurfZonesApiEndpoint string   `env:"TURF_API_ZONES_URL"`
+	TurfUsers            []string `env:"TURF_USERS, required"`
+	TurfZones            []string `env:"TURF_ZONES"`
+	TurfRoundsEndpoint   string   `env:"TURF_API_ROUNDS_URL"`
+	TurfRoundsEnabled    bool     `env:"TURF_ROUNDS_ENABLED, default=false"`
+	TurfRoundLabel       bool     `env:"TURF_ROUND_LABEL, default=false"`
+	TurfRegionsEndpoint  string   `env:"TURF_API_REGIONS_URL"`
+	TurfRegions          []string `env:"TURF_REGIONS"`
+	TurfRegionZones      []string `env:"TURF_REGION_ZONES"`
+	TurfToplistEndpoint  string   `env:"TURF_API_TOPLIST_URL"`
+	TurfToplists         []string `env:"TURF_TOPLISTS"`
+	TurfToplistSize      int      `env:"TURF_TOPLIST_SIZE, default=10"`
+	TurfStatsEndpoint    string   `env:"TURF_API_STATISTICS_URL"`
+	TurfStatsEnabled     bool     `env:"TURF_STATISTICS_ENABLED, default=false"`
+	TurfFeedsEndpoint    string   `env:"TURF_API_FEEDS_URL"`
+	TurfFeeds            []string `env:"TURF_FEEDS"`
+	TurfUserZones        bool     `env:"TURF_USER_ZONES_ENABLED, default=false"`
+	TurfUserMedals       bool     `env:"TURF_USER_MEDALS_ENABLED, default=false"`
+	TurfMedalsFile       string   `env:"TURF_MEDALS_FILE"`
+	TurfTeams            string   `env:"TURF_TEAMS"`
+	TurfCountryLabel     bool     `env:"TURF_COUNTRY_LABEL, default=false"`
+	TurfUserLabels       string   `env:"TURF_USER_LABELS"`
+	TurfUsersValidation  string   `env:"TURF_USERS_VALIDATION, default=warn"`
+	TurfPriorityUsers    []string `env:"TURF_PRIORITY_USERS"`
+	TurfAnonymizeUsers   string   `env:"TURF_ANONYMIZE_USERS"`
+	TurfAnonymizeSalt    string   `env:"TURF_ANONYMIZE_SALT"`
+	TurfDemo             bool     `env:"TURF_DEMO_ENABLED, default=false"`
+	TracingEnabled       bool     `env:"TRACING_ENABLED, default=false"`
+	MetricNamespace      string   `env:"METRIC_NAMESPACE"`
+	MetricTimestamps     bool     `env:"METRIC_TIMESTAMPS_ENABLED, default=false"`
+	DisabledMetrics      []string `env:"DISABLE_METRICS"`
+	GoCollector          bool     `env:"GO_COLLECTOR_ENABLED, default=true"`
+	ProcessCollector     bool     `env:"PROCESS_COLLECTOR_ENABLED, default=true"`
+	PollIntervalSec      int      `env:"POLL_INTERVAL_SEC, default=300"`
+	UsersIntervalSec     int      `env:"USERS_POLL_INTERVAL_SEC"`
+	ZonesIntervalSec     int      `env:"ZONES_POLL_INTERVAL_SEC"`
+	RoundsIntervalSec    int      `env:"ROUNDS_POLL_INTERVAL_SEC"`
+	RegionsIntervalSec   int      `env:"REGIONS_POLL_INTERVAL_SEC"`
+	ToplistsIntervalSec  int      `env:"TOPLISTS_POLL_INTERVAL_SEC"`
+	StatsIntervalSec     int      `env:"STATISTICS_POLL_INTERVAL_SEC"`
+	FeedsIntervalSec     int      `env:"FEEDS_POLL_INTERVAL_SEC"`
+	PriorityIntervalSec  int      `env:"PRIORITY_POLL_INTERVAL_SEC, default=60"`
+	ConditionalRequests  bool     `env:"CONDITIONAL_REQUESTS_ENABLED, default=false"`
+	AdaptivePolling      bool     `env:"ADAPTIVE_POLLING_ENABLED, default=false"`
+	AdaptiveMinSec       int      `env:"ADAPTIVE_POLL_MIN_SEC, default=60"`
+	AdaptiveMaxSec       int      `env:"ADAPTIVE_POLL_MAX_SEC, default=900"`
+	ApiTimeoutSec        int      `env:"API_TIMEOUT_SEC, default=10"`
+	ApiRetries           int      `env:"API_RETRIES, default=2"`
+	ApiRateLimit         float64  `env:"API_RATE_LIMIT, default=1"`
+	ApiRateBurst         int      `env:"API_RATE_BURST, default=1"`
+	ApiWorkers           int      `env:"API_WORKERS, default=4"`
+	ApiMaxResponseMB     int      `env:"API_MAX_RESPONSE_MB, default=64"`
+	ApiCompressRequests  bool     `env:"API_COMPRESS_REQUESTS_ENABLED, default=false"`
+	ApiMaxIdleConns      int      `env:"API_MAX_IDLE_CONNS, default=100"`
+	ApiIdleTimeoutSec    int      `env:"API_IDLE_CONN_TIMEOUT_SEC, default=90"`
+	ApiKeepAlives        bool     `env:"API_KEEP_ALIVES_ENABLED, default=true"`
+	ApiUserAgent         string   `env:"API_USER_AGENT, default=go-turfgame-exporter"`
+	ApiHeaders           []string `env:"API_HEADERS"`
+	ApiDebugPayloads     bool     `env:"API_DEBUG_PAYLOADS_ENABLED, default=false"`
+	ApiPayloadFields     []string `env:"API_DEBUG_PAYLOAD_FIELDS, default=errorMessage,error,message"`
+	ApiPayloadBytes      int      `env:"API_DEBUG_PAYLOAD_BYTES, default=4096"`
+	ApiProxyUrl          string   `env:"API_PROXY_URL"`
+	ApiProxyUser         string   `env:"API_PROXY_USERNAME"`
+	ApiProxyPassword     string   `env:"API_PROXY_PASSWORD"`
+	ApiCaFile            string   `env:"API_CA_FILE"`
+	ApiCertFile          string   `env:"API_CERT_FILE"`
+	ApiKeyFile           string   `env:"API_KEY_FILE"`
+	ApiInsecure          bool     `env:"API_INSECURE_SKIP_VERIFY, default=false"`
+	ApiRetryDelaySec     int      `env:"API_RETRY_DELAY_SEC, default=1"`
+	BreakerFailures      int      `env:"CIRCUIT_BREAKER_FAILURES, default=0"`
+	BreakerCooldownSec   int      `env:"CIRCUIT_BREAKER_COOLDOWN_SEC, default=60"`
+	ScrapeRefresh        bool     `env:"SCRAPE_REFRESH_ENABLED, default=false"`
+	MinRefreshSec        int      `env:"MIN_REFRESH_INTERVAL_SEC, default=60"`
+	MaxDataAgeSec        int      `env:"MAX_DATA_AGE_SEC, default=0"`
+	StateFile            string   `env:"STATE_FILE"`
+	PersistUsers         bool     `env:"PERSIST_USERS_ENABLED, default=false"`
+	ConfigWatch          bool     `env:"CONFIG_WATCH_ENABLED, default=false"`
+	StartupWaitSec       int      `env:"STARTUP_WAIT_SEC, default=5"`
+	HttpPort             string   `env:"HTTPD_PORT, default=9097"`
+	ListenAddress        string   `env:"WEB_LISTEN_ADDRESS"`
+	SocketMode           string   `env:"WEB_SOCKET_MODE"`
+	WebConfigFile        string   `env:"WEB_CONFIG_FILE"`
+	TelemetryPath        string   `env:"WEB_TELEMETRY_PATH, default=/metrics"`
+	LandingUsers         bool     `env:"WEB_LANDING_PAGE_USERS_ENABLED, default=false"`
+	ReadTimeoutSec       int      `env:"WEB_READ_TIMEOUT_SEC, default=10"`
+	WriteTimeoutSec      int      `env:"WEB_WRITE_TIMEOUT_SEC, default=60"`
+	IdleTimeoutSec       int      `env:"WEB_IDLE_TIMEOUT_SEC, default=120"`
+	MaxHeaderBytes       int      `env:"WEB_MAX_HEADER_BYTES, default=1048576"`
+	HealthStallSec       int      `env:"HEALTHZ_STALL_SEC, default=300"`
+	ReadyMaxAgeSec       int      `env:"READYZ_MAX_DATA_AGE_SEC, default=0"`
+	AdminToken           string   `env:"ADMIN_TOKEN"`
+	AdminAddress         string   `env:"WEB_ADMIN_LISTEN_ADDRESS"`
+	PprofAddress         string   `env:"PPROF_LISTEN_ADDRESS"`
+	LogLevel             string   `env:"LOG_LEVEL, default=info"`
+	LogFormat            string   `env:"LOG_FORMAT, default=logfmt"`
+}
+
+// userZones holds the resolved zones currently owned by a user.
+type userZones struct {
+	User  string
+	Zones []turf.Zone
+}
+
+// toplist is a leaderboard as returned by the toplist endpoint for a scope such as
+// "global", "country/se" or "region/141".
+type toplist struct {
+	Scope string
+	Users []turf.User
 }
 
 // Metrics
@@ -50,95 +150,211 @@ var (
 			Name: "turfgame_api_requests_total",
 			Help: "Total number of requests to Turfgame API",
 		},
-		[]string{"status"},
+		[]string{"method", "endpoint", "status", "status_class"},
 	)
 
-	zonesOwned = prometheus.NewGaugeVec(
+	zoneTakeovers = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "turfgame_user_zones_owned",
-			Help: "Number of zones owned",
+			Name: "turfgame_zone_takeovers",
+			Help: "Total number of takeovers of the zone",
 		},
-		[]string{"user"},
+		[]string{"zone"},
 	)
 
-	pointsPerHour = prometheus.NewGaugeVec(
+	zonePointsPerHour = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "turfgame_user_points_per_hour",
-			Help: "Number of points received per hour",
+			Name: "turfgame_zone_points_per_hour",
+			Help: "Number of points per hour the zone gives its owner",
 		},
-		[]string{"user"},
+		[]string{"zone"},
 	)
 
-	roundPoints = prometheus.NewGaugeVec(
+	zoneTakePoints = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "turfgame_user_points",
-			Help: "Number of points received in this round",
+			Name: "turfgame_zone_take_points",
+			Help: "Number of points received when taking the zone",
 		},
-		[]string{"user"},
+		[]string{"zone"},
 	)
 
-	blocktime = prometheus.NewGaugeVec(
+	zoneOwner = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "turfgame_user_blocktime",
-			Help: "The users blocktime",
+			Name: "turfgame_zone_owner",
+			Help: "The zones current owner",
 		},
-		[]string{"user"},
+		[]string{"zone", "owner"},
 	)
 
-	takenZones = prometheus.NewGaugeVec(
+	zoneLastTaken = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "turfgame_user_taken",
-			Help: "Number of zones taken",
+			Name: "turfgame_zone_last_taken_timestamp_seconds",
+			Help: "Unix timestamp of when the zone was last taken",
 		},
-		[]string{"user"},
+		[]string{"zone"},
 	)
 
-	totalPoints = prometheus.NewGaugeVec(
+	zoneHeld = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "turfgame_user_total_points",
-			Help: "The users total points",
+			Name: "turfgame_zone_held_seconds",
+			Help: "Number of seconds the current owner has held the zone",
+		},
+		[]string{"zone", "owner"},
+	)
+
+	roundId = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_round_id",
+			Help: "Sequence number of the current round",
+		},
+		[]string{"round"},
+	)
+
+	roundStart = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_round_start_timestamp_seconds",
+			Help: "Unix timestamp of when the current round started",
+		},
+		[]string{"round"},
+	)
+
+	roundEnd = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_round_end_timestamp_seconds",
+			Help: "Unix timestamp of when the current round ends",
+		},
+		[]string{"round"},
+	)
+
+	roundNumber = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "turfgame_round_number",
+			Help: "Sequence number of the current round",
+		},
+	)
+
+	roundChanges = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "turfgame_round_changes_total",
+			Help: "Number of times a new round has been detected since the exporter started",
+		},
+	)
+
+	// currentRoundEnd is the Unix timestamp of when the current round ends, 0 if unknown.
+	currentRoundEnd atomic.Int64
+
+	roundSecondsRemaining = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "turfgame_round_seconds_remaining",
+			Help: "Number of seconds until the current round ends",
+		},
+		func() float64 {
+			end := currentRoundEnd.Load()
+			if end == 0 {
+				return 0
+			}
+			return max(0, float64(end-time.Now().Unix()))
 		},
-		[]string{"user"},
 	)
 
-	userRank = prometheus.NewGaugeVec(
+	regionInfo = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "turfgame_user_rank",
-			Help: "The users rank",
+			Name: "turfgame_region_info",
+			Help: "Information about the region",
 		},
-		[]string{"user"},
+		[]string{"region", "region_id", "country", "area"},
 	)
 
-	place = prometheus.NewGaugeVec(
+	regionZones = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "turfgame_user_place",
-			Help: "The users place",
+			Name: "turfgame_region_zones",
+			Help: "Number of zones in the region",
 		},
-		[]string{"user"},
+		[]string{"region"},
 	)
 
-	uniqueZones = prometheus.NewGaugeVec(
+	regionNeutralZones = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "turfgame_user_unique_zones_taken",
-			Help: "Number of unique zones the user has taken",
+			Name: "turfgame_region_neutral_zones",
+			Help: "Number of zones in the region without an owner",
 		},
-		[]string{"user"},
+		[]string{"region"},
 	)
 
-	medalsTaken = prometheus.NewGaugeVec(
+	regionWatchedZones = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "turfgame_user_medals_taken",
-			Help: "Number of medals the user has taken",
+			Name: "turfgame_region_watched_zones",
+			Help: "Number of zones in the region owned by the watched users",
 		},
-		[]string{"user"},
+		[]string{"region"},
 	)
 
-	region = prometheus.NewGaugeVec(
+	regionPointsPerHour = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "turfgame_user_region",
-			Help: "The users current region",
+			Name: "turfgame_region_points_per_hour",
+			Help: "Sum of points per hour of all zones in the region",
 		},
-		[]string{"user", "region"},
+		[]string{"region"},
+	)
+
+	toplistPoints = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_toplist_points",
+			Help: "Points of the players on the toplist",
+		},
+		[]string{"scope", "position", "user"},
+	)
+
+	statsZones = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "turfgame_statistics_zones",
+			Help: "Total number of zones in Turf",
+		},
+	)
+
+	statsPlayers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "turfgame_statistics_players",
+			Help: "Total number of players in Turf",
+		},
+	)
+
+	statsZonesTakenToday = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "turfgame_statistics_zones_taken_today",
+			Help: "Number of zones taken today",
+		},
+	)
+
+	chatMessages = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "turfgame_chat_messages_total",
+			Help: "Number of chat messages sent since the exporter started",
+		},
+		[]string{"region"},
+	)
+
+	regionLord = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_region_lord_info",
+			Help: "The regions current lord",
+		},
+		[]string{"region", "user"},
+	)
+
+	teamPoints = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_team_points",
+			Help: "Number of points received in this round by the members of the team",
+		},
+		[]string{"team"},
+	)
+
+	teamZonesOwned = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_team_zones_owned",
+			Help: "Number of zones owned by the members of the team",
+		},
+		[]string{"team"},
 	)
 
 	requestDurations = prometheus.NewHistogramVec(
@@ -148,114 +364,1464 @@ var (
 			// Bucket configuration: the first bucket includes all requests finishing in 0.05 seconds, the last one includes all requests finishing in 10 seconds.
 			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
 		},
+		[]string{"method", "endpoint", "status_class"},
+	)
+
+	lastSuccessfulPoll = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_last_successful_poll_timestamp_seconds",
+			Help: "Unix timestamp of the last successful poll of the endpoint",
+		},
+		[]string{"url"},
+	)
+
+	lastPollError = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_last_poll_error",
+			Help: "Whether the last poll of the endpoint failed",
+		},
 		[]string{"url"},
 	)
+
+	pollDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "turfgame_poll_duration_seconds",
+			Help:    "Duration of the polls of the users",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+
+	pollUsersReturned = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "turfgame_poll_users_returned",
+			Help: "Number of users returned by the last successful poll of the users",
+		},
+	)
+
+	polls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "turfgame_polls_total",
+			Help: "Number of polls of the users, by result: success, partial if some of the users were not returned, or error",
+		},
+		[]string{"result"},
+	)
+
+	busyWorkers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "turfgame_api_workers_busy",
+			Help: "Number of polls currently requesting Turfgame API",
+		},
+	)
+
+	apiErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "turfgame_api_errors_total",
+			Help: "Number of error responses from Turfgame API, by HTTP status text",
+		},
+		[]string{"reason"},
+	)
+
+	invalidResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "turfgame_api_invalid_responses_total",
+			Help: "Number of responses from Turfgame API that could not be decoded, by reason: too_large or not_json",
+		},
+		[]string{"reason"},
+	)
+
+	apiRetries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "turfgame_api_retries_total",
+			Help: "Number of failed requests to Turfgame API that were retried within the poll",
+		},
+	)
+
+	rateLimited = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "turfgame_api_rate_limited_total",
+			Help: "Number of requests to Turfgame API that were rejected as rate limited",
+		},
+	)
+
+	configInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_exporter_config_info",
+			Help: "Configuration of the exporter",
+		},
+		[]string{"poll_interval_seconds", "api_endpoint", "users"},
+	)
+
+	configuredUsers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "turfgame_configured_users",
+			Help: "Number of users configured to be watched",
+		},
+	)
 )
 
-func main() {
-	ctx := context.Background()
-	var c Config
+// Per-user metrics, created by newUserMetrics. Those built from the users snapshot at
+// scrape time are described by a userDesc, the others are long-lived vectors.
+var (
+	userMetrics []userMetric
+	userDescs   []userDesc
+
+	roundPoints         userDesc
+	zonesOwned          userDesc
+	zonesGained         *prometheus.CounterVec
+	zonesLost           *prometheus.CounterVec
+	pointsPerHour       userDesc
+	blocktime           userDesc
+	blocktimeSeconds    userDesc
+	takenZones          userDesc
+	takenZonesCounter   *prometheus.CounterVec
+	totalPoints         userDesc
+	totalPointsCounter  *prometheus.CounterVec
+	userRank            userDesc
+	place               userDesc
+	uniqueZones         userDesc
+	medalsTaken         userDesc
+	region              userDesc
+	userInfo            userDesc
+	userTakeovers       *prometheus.CounterVec
+	userAssists         *prometheus.CounterVec
+	userMedalEvents     *prometheus.CounterVec
+	userLastMedal       *prometheus.GaugeVec
+	userZoneOwned       *prometheus.GaugeVec
+	userOwnedZonesPph   *prometheus.GaugeVec
+	userCountryPlace    *prometheus.GaugeVec
+	userMedal           userDesc
+	userLastActivity    *prometheus.GaugeVec
+	userFound           *prometheus.GaugeVec
+	uniqueZonesToMedal  userDesc
+	userZonesLostEvents *prometheus.CounterVec
+)
 
-	if err := envconfig.Process(ctx, &c); err != nil {
-		log.Fatal(err)
+// userMetric is any of the long-lived per-user metric vectors.
+type userMetric interface {
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// deleteUserSeries removes all series of user from the per-user metrics, or only those
+// with the additional labels given as name/value pairs.
+func deleteUserSeries(user string, labelValues ...string) {
+	labels := prometheus.Labels{"user": userAliases.Name(user)}
+	for i := 0; i+1 < len(labelValues); i += 2 {
+		labels[labelValues[i]] = labelValues[i+1]
+	}
+	for _, m := range userMetrics {
+		m.DeletePartialMatch(labels)
 	}
+}
+
+// newUserMetrics creates the per-user metrics. Their labels depend on the configuration,
+// see userLabels, so this has to be called before any of them are used.
+func newUserMetrics(roundLabel bool) {
+	pointsLabels := userLabels.Names()
+	if roundLabel {
+		pointsLabels = userLabels.Names("round")
+	}
+
+	roundPoints = newUserDesc(
+		"turfgame_user_points",
+		"Number of points received in this round",
+		pointsLabels,
+	)
 
-	go backgroundJob(c)
+	zonesOwned = newUserDesc(
+		"turfgame_user_zones_owned",
+		"Number of zones owned",
+		userLabels.Names(),
+	)
+
+	zonesGained = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "turfgame_user_zones_gained_total",
+			Help: "Number of zones that appeared in the users zone list between polls",
+		},
+		userLabels.Names(),
+	)
+
+	zonesLost = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "turfgame_user_zones_lost_total",
+			Help: "Number of zones that disappeared from the users zone list between polls",
+		},
+		userLabels.Names(),
+	)
+
+	pointsPerHour = newUserDesc(
+		"turfgame_user_points_per_hour",
+		"Number of points received per hour",
+		userLabels.Names(),
+	)
+
+	blocktime = newUserDesc(
+		"turfgame_user_blocktime",
+		"The users blocktime",
+		userLabels.Names(),
+	)
+
+	blocktimeSeconds = newUserDesc(
+		"turfgame_user_blocktime_seconds",
+		"The users blocktime in seconds",
+		userLabels.Names(),
+	)
+
+	takenZones = newUserDesc(
+		"turfgame_user_taken",
+		"Number of zones taken",
+		userLabels.Names(),
+	)
+
+	takenZonesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "turfgame_user_takeovers_derived_total",
+			Help: "Number of zones taken, derived from the change of turfgame_user_taken between polls",
+		},
+		userLabels.Names(),
+	)
+
+	totalPoints = newUserDesc(
+		"turfgame_user_total_points",
+		"The users total points",
+		userLabels.Names(),
+	)
+
+	totalPointsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "turfgame_user_total_points_total",
+			Help: "The users total points",
+		},
+		userLabels.Names(),
+	)
+
+	userRank = newUserDesc(
+		"turfgame_user_rank",
+		"The users rank",
+		userLabels.Names(),
+	)
+
+	place = newUserDesc(
+		"turfgame_user_place",
+		"The users place",
+		userLabels.Names(),
+	)
 
-	prometheus.MustRegister(turfgameApiRequestsTotal)
-	prometheus.MustRegister(roundPoints)
-	prometheus.MustRegister(zonesOwned)
-	prometheus.MustRegister(pointsPerHour)
-	prometheus.MustRegister(blocktime)
-	prometheus.MustRegister(takenZones)
-	prometheus.MustRegister(totalPoints)
-	prometheus.MustRegister(userRank)
-	prometheus.MustRegister(place)
-	prometheus.MustRegister(uniqueZones)
-	prometheus.MustRegister(medalsTaken)
-	prometheus.MustRegister(region)
-	prometheus.MustRegister(requestDurations)
+	uniqueZones = newUserDesc(
+		"turfgame_user_unique_zones_taken",
+		"Number of unique zones the user has taken",
+		userLabels.Names(),
+	)
+
+	medalsTaken = newUserDesc(
+		"turfgame_user_medals_taken",
+		"Number of medals the user has taken",
+		userLabels.Names(),
+	)
+
+	region = newUserDesc(
+		"turfgame_user_region",
+		"The users current region",
+		userLabels.Names("region"),
+	)
+
+	userInfo = newUserDesc(
+		"turfgame_user_info",
+		"Information about the user",
+		userLabels.Names("id", "country", "region"),
+	)
+
+	userTakeovers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "turfgame_user_takeovers_total",
+			Help: "Number of takeovers made by the user since the exporter started",
+		},
+		userLabels.Names(),
+	)
+
+	userAssists = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "turfgame_user_assists_total",
+			Help: "Number of takeovers assisted by the user since the exporter started",
+		},
+		userLabels.Names(),
+	)
+
+	userMedalEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "turfgame_user_medal_events_total",
+			Help: "Number of medals earned by the user since the exporter started",
+		},
+		userLabels.Names(),
+	)
+
+	userLastMedal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_user_last_medal_timestamp_seconds",
+			Help: "Unix timestamp of the last medal earned by the user",
+		},
+		userLabels.Names(),
+	)
+
+	userZoneOwned = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_user_zone_owned",
+			Help: "Zones currently owned by the user",
+		},
+		userLabels.Names("zone_name", "zone_id"),
+	)
+
+	userOwnedZonesPph = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_user_owned_zones_pph",
+			Help: "Sum of points per hour of the zones currently owned by the user",
+		},
+		userLabels.Names(),
+	)
 
-	http.Handle("/metrics", promhttp.Handler())
-	http.ListenAndServe(":"+c.HttpPort, nil)
+	userCountryPlace = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_user_country_place",
+			Help: "The users place on the country toplist",
+		},
+		userLabels.Names("country"),
+	)
+
+	userMedal = newUserDesc(
+		"turfgame_user_medal",
+		"Whether the user has taken the medal",
+		userLabels.Names("medal_id", "medal_name"),
+	)
+
+	userLastActivity = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_user_last_activity_timestamp_seconds",
+			Help: "Unix timestamp of the users last observed takeover, assist or medal",
+		},
+		userLabels.Names(),
+	)
+
+	userFound = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "turfgame_user_found",
+			Help: "Whether the configured user was found by Turfgame API, 0 if e.g. the name is misspelled",
+		},
+		userLabels.Names(),
+	)
+
+	uniqueZonesToMedal = newUserDesc(
+		"turfgame_user_unique_zones_to_next_medal",
+		"Number of unique zones the user has left to take to reach the next unique-zone medal of TURF_MEDALS_FILE",
+		userLabels.Names(),
+	)
+
+	userZonesLostEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "turfgame_user_zones_lost_events_total",
+			Help: "Number of times the zone was taken from the user since the exporter started",
+		},
+		userLabels.Names("zone_name"),
+	)
+
+	userMetrics = []userMetric{
+		zonesGained, zonesLost, takenZonesCounter, totalPointsCounter, userTakeovers, userAssists,
+		userMedalEvents, userLastMedal, userZoneOwned, userOwnedZonesPph, userCountryPlace,
+		userLastActivity, userZonesLostEvents,
+	}
+
+	userDescs = []userDesc{
+		roundPoints, zonesOwned, pointsPerHour, blocktime, blocktimeSeconds, takenZones, totalPoints,
+		userRank, place, uniqueZones, uniqueZonesToMedal, medalsTaken, region, userInfo, userMedal,
+	}
 }
 
-func backgroundJob(c Config) {
-	if len(c.TurfUsers) == 0 {
-		log.Fatal("TURF_USERS cannot be an empty string")
+func main() {
+	// ctx is cancelled on SIGINT, SIGTERM or /-/quit, which stops the polls and the HTTP server.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	configPath := flag.String("config", "", "Path of a YAML configuration file, overridden by environment variables")
+	once := flag.Bool("dry-run", false, "Poll the users once, print the metrics and exit, with status 1 if the poll failed")
+	printConfig := flag.Bool("print-config", false, "Print the configuration in effect, with secrets redacted, and exit")
+	flags := envFlags(flag.CommandLine)
+	flag.Parse()
+
+	c, err := loadConfig(ctx, *configPath, flags)
+	if err != nil {
+		fatal(err.Error())
+	}
+	logger, err := newLogger(c.LogLevel, c.LogFormat)
+	if err != nil {
+		fatal(err.Error())
+	}
+	slog.SetDefault(logger)
+
+	if err := c.resolveEndpoints(); err != nil {
+		fatal(err.Error())
+	}
+	if *printConfig {
+		fmt.Print(effectiveConfig(c))
+		return
+	}
+
+	if c.TurfRoundLabel && !c.TurfRoundsEnabled {
+		fatal("TURF_ROUND_LABEL requires TURF_ROUNDS_ENABLED")
 	}
 
-	var users []map[string]string
-	ch := make(chan []User)
+	if c.ConfigWatch && *configPath == "" {
+		fatal("CONFIG_WATCH_ENABLED requires --config")
+	}
+
+	if c.PersistUsers && c.StateFile == "" {
+		fatal("PERSIST_USERS_ENABLED requires STATE_FILE")
+	}
+
+	t, err := parseTeams(c.TurfTeams)
+	if err != nil {
+		fatal(err.Error())
+	}
+	if len(t.names) > 0 {
+		userLabels.Add("team", t.members)
+	}
+
+	if c.TurfCountryLabel {
+		// The values are set as the users countries become known.
+		userLabels.Add("country", nil)
+	}
 
-	for _, u := range c.TurfUsers {
-		user := map[string]string{
-			"name": u,
+	if c.TurfMedalsFile != "" {
+		if medals, err = loadMedals(c.TurfMedalsFile); err != nil {
+			fatal(err.Error())
 		}
-		users = append(users, user)
 	}
 
-	client := http.Client{
-		Timeout: 10 * time.Second,
+	userAliases, err = newAnonymizer(c.TurfAnonymizeUsers, c.TurfAnonymizeSalt)
+	if err != nil {
+		fatal(err.Error())
 	}
+	userAliases.Number(c.TurfUsers)
 
-	go fetchData(c, client, users, ch)
+	names, values, err := parseUserLabels(c.TurfUserLabels)
+	if err != nil {
+		fatal(err.Error())
+	}
+	for _, name := range names {
+		userLabels.Add(name, values[name])
+	}
 
-	for {
-		data := <-ch
+	newUserMetrics(c.TurfRoundLabel)
 
-		for _, user := range data {
-			roundPoints.WithLabelValues(user.Name).Set(float64(user.Points))
-			zonesOwned.WithLabelValues(user.Name).Set(float64(len(user.Zones)))
-			pointsPerHour.WithLabelValues(user.Name).Set(float64(user.PointsPerHour))
-			blocktime.WithLabelValues(user.Name).Set(float64(user.Blocktime))
-			takenZones.WithLabelValues(user.Name).Set(float64(user.Taken))
-			totalPoints.WithLabelValues(user.Name).Set(float64(user.TotalPoints))
-			userRank.WithLabelValues(user.Name).Set(float64(user.Rank))
-			place.WithLabelValues(user.Name).Set(float64(user.Place))
-			uniqueZones.WithLabelValues(user.Name).Set(float64(user.UniqueZonesTaken))
-			medalsTaken.WithLabelValues(user.Name).Set(float64(len(user.Medals)))
-			region.WithLabelValues(user.Name, user.Region.Name).Set(1)
+	e := newExporter(c)
+
+	e.Add(
+		turfgameApiRequestsTotal,
+		zonesGained,
+		zonesLost,
+		takenZonesCounter,
+		totalPointsCounter,
+		zoneTakeovers,
+		zonePointsPerHour,
+		zoneTakePoints,
+		zoneOwner,
+		zoneLastTaken,
+		zoneHeld,
+		roundId,
+		roundStart,
+		roundEnd,
+	)
+	if c.TurfRoundsEnabled {
+		e.Add(roundSecondsRemaining, roundNumber)
+	}
+	e.Add(
+		roundChanges,
+		regionInfo,
+		regionZones,
+		regionLord,
+		regionNeutralZones,
+		regionWatchedZones,
+		regionPointsPerHour,
+		toplistPoints,
+		userCountryPlace,
+		userLastActivity,
+		userFound,
+		userTakeovers,
+		userAssists,
+		userZonesLostEvents,
+		userMedalEvents,
+		userLastMedal,
+		chatMessages,
+		userZoneOwned,
+		userOwnedZonesPph,
+	)
+	if c.TurfStatsEnabled {
+		e.Add(statsZones, statsPlayers, statsZonesTakenToday)
+	}
+	if len(t.names) > 0 {
+		e.Add(teamPoints, teamZonesOwned)
+	}
+	if c.BreakerFailures > 0 {
+		e.Add(circuitBreakerState)
+	}
+	if c.ApiRateLimit > 0 {
+		e.Add(throttledRequests)
+	}
+	if c.AdaptivePolling {
+		e.Add(usersPollInterval)
+	}
+	if len(c.TurfApiUrl) > 1 {
+		e.Add(activeApiUrl)
+	}
+	e.Add(requestDurations, apiRequestPhases, apiConnections, apiErrors, invalidResponses, busyWorkers, apiRetries, rateLimited, lastSuccessfulPoll, lastPollError,
+		pollDuration, pollUsersReturned, polls, configInfo, configuredUsers)
+	e.CheckDisabled()
+
+	registry := prometheus.NewRegistry()
+	if c.GoCollector {
+		registry.MustRegister(collectors.NewGoCollector())
+	}
+	if c.ProcessCollector {
+		registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+
+	var reg prometheus.Registerer = registry
+	if c.MetricNamespace != "" {
+		reg = prometheus.WrapRegistererWithPrefix(c.MetricNamespace+"_", reg)
+	}
+	reg.MustRegister(e)
+
+	if *once {
+		if err := dryRun(ctx, c, e, registry, os.Stdout); err != nil {
+			fatal("Dry run failed", "err", err)
+		}
+		return
+	}
+	go backgroundJob(ctx, c, t, e)
+
+	if err := checkTelemetryPath(c.TelemetryPath); err != nil {
+		fatal(err.Error(), "path", c.TelemetryPath)
+	}
+	mux := http.NewServeMux()
+	// With WEB_ADMIN_LISTEN_ADDRESS the health and admin endpoints are served apart from the
+	// metrics, e.g. on localhost only.
+	adminMux := mux
+	if c.AdminAddress != "" {
+		adminMux = http.NewServeMux()
+	}
+	// With TRACING_ENABLED the API requests of scrapes and polls join their trace.
+	traced := func(h http.Handler) http.Handler {
+		if c.TracingEnabled {
+			return traceHandler(h)
+		}
+		return h
+	}
+	mux.Handle(c.TelemetryPath, traced(promhttp.InstrumentMetricHandler(
+		registry,
+		// Exemplars are only exposed in the OpenMetrics format.
+		e.RefreshOnScrape(promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: c.TracingEnabled})),
+	)))
+	if c.TurfUserZones {
+		mux.HandleFunc("/geojson", geoJSONHandler)
+	}
+	adminMux.Handle("GET /healthz", healthzHandler(e, time.Duration(c.HealthStallSec)*time.Second))
+	adminMux.Handle("GET /readyz", readyzHandler(e, time.Duration(c.ReadyMaxAgeSec)*time.Second))
+	landing, err := landingPage(c)
+	if err != nil {
+		fatal(err.Error())
+	}
+	mux.Handle("GET /{$}", landing)
+	reload := func() error {
+		return reloadConfig(ctx, *configPath, flags, c, e)
+	}
+	if c.ConfigWatch {
+		go watchConfigFile(ctx, *configPath, reload)
+	}
+	if c.AdminToken != "" {
+		adminMux.Handle("/-/poll", traced(requireToken(c.AdminToken, pollHandler(e))))
+		adminMux.Handle("/-/reload", requireToken(c.AdminToken, reloadHandler(reload)))
+		adminMux.Handle("/-/quit", requireToken(c.AdminToken, quitHandler(stop)))
+		adminMux.Handle("POST /api/v1/users/{name}", requireToken(c.AdminToken, addUserHandler(e)))
+		adminMux.Handle("DELETE /api/v1/users/{name}", requireToken(c.AdminToken, removeUserHandler(e)))
+	}
+
+	if err := web.Validate(c.WebConfigFile); err != nil {
+		fatal("Invalid WEB_CONFIG_FILE", "err", err)
+	}
+	// Basic auth and ADMIN_TOKEN are both sent in the Authorization header, so no request
+	// could pass both.
+	auth, err := basicAuth(c.WebConfigFile)
+	if err != nil {
+		fatal("Invalid WEB_CONFIG_FILE", "err", err)
+	}
+	if auth && c.AdminToken != "" && c.AdminAddress == "" {
+		fatal("ADMIN_TOKEN can't be used with the basic auth of WEB_CONFIG_FILE unless the admin endpoints are served on WEB_ADMIN_LISTEN_ADDRESS")
+	}
+	listener, err := c.listen()
+	if err != nil {
+		fatal(err.Error())
+	}
+	// The timeouts keep slow or idle clients from holding on to connections.
+	server := &http.Server{
+		Handler:        mux,
+		ReadTimeout:    time.Duration(c.ReadTimeoutSec) * time.Second,
+		WriteTimeout:   time.Duration(c.WriteTimeoutSec) * time.Second,
+		IdleTimeout:    time.Duration(c.IdleTimeoutSec) * time.Second,
+		MaxHeaderBytes: c.MaxHeaderBytes,
+	}
+	go func() {
+		if err := c.serve(server, listener); !errors.Is(err, http.ErrServerClosed) {
+			fatal(err.Error())
+		}
+	}()
+	if c.PprofAddress != "" {
+		expvar.Publish("turfgame", expvar.Func(func() any { return e.State() }))
+	}
+	// servers are the servers of the admin and debug endpoints, if served apart.
+	var servers []*http.Server
+	if c.AdminAddress != "" {
+		if c.PprofAddress == c.AdminAddress {
+			adminMux.Handle("/debug/", debugHandler(c))
+		}
+		adminServer, err := c.servePlain("WEB_ADMIN_LISTEN_ADDRESS", c.AdminAddress, adminMux)
+		if err != nil {
+			fatal(err.Error())
+		}
+		servers = append(servers, adminServer)
+	}
+	if c.PprofAddress != "" && c.PprofAddress != c.AdminAddress {
+		pprofServer, err := c.servePlain("PPROF_LISTEN_ADDRESS", c.PprofAddress, debugHandler(c))
+		if err != nil {
+			fatal(err.Error())
+		}
+		servers = append(servers, pprofServer)
+	}
+
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down")
+	sdNotify("STOPPING=1")
+
+	// Give in-flight scrapes a moment to finish.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("An Error Occured", "err", err)
+	}
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("An Error Occured", "err", err)
 		}
 	}
 }
 
-func fetchData(c Config, client http.Client, users []map[string]string, ch chan []User) <-chan []User {
-	json_body, _ := json.Marshal(users)
-	var turfData []User
+func backgroundJob(ctx context.Context, c Config, t teams, e *exporter) {
+	refs, err := parseUserRefs(c.TurfUsers)
+	if err != nil {
+		fatal(err.Error())
+	}
 
-	turfgameApiRequestsTotal.WithLabelValues("ok")
-	turfgameApiRequestsTotal.WithLabelValues("error")
+	ch := make(chan []turf.User)
+	zoneCh := make(chan []turf.Zone)
+	roundCh := make(chan []turf.Round)
+	regionCh := make(chan []turf.Region)
+	allZonesCh := make(chan []turf.Zone)
+	toplistCh := make(chan toplist)
+	statsCh := make(chan turf.Statistics)
+	feedCh := make(chan feedEvents)
+	userZonesCh := make(chan []userZones)
 
-	for {
-		requestStart := time.Now()
-		resp, err := client.Post(c.TurfApiEndpoint, "application/json", bytes.NewBuffer(json_body))
-		duration := time.Since(requestStart)
-		requestDurations.WithLabelValues(c.TurfApiEndpoint).Observe(duration.Seconds())
+	st, err := loadState(c.StateFile)
+	if err != nil {
+		fatal(err.Error())
+	}
+	if c.PersistUsers && len(st.Users) > 0 {
+		if refs, err = parseUserRefs(st.Users); err != nil {
+			fatal(err.Error())
+		}
+		slog.Info("Watching the users saved in STATE_FILE instead of TURF_USERS", "users", len(refs))
+	}
+	if c.TurfAnonymizeUsers == "alias" && st.Aliases != nil {
+		// The persisted series are labeled with the aliases of the previous run.
+		userAliases.Restore(st.Aliases)
+		userAliases.Number(c.TurfUsers)
+	}
+	rounds := newRoundTracker()
+	rounds.name, rounds.number, rounds.points = st.RoundName, st.RoundNumber, st.RoundPoints
+	totals := st.Totals
+	taken := st.Taken
+	owned := st.Owned
+	activity := st.Restore(c.TurfCountryLabel)
+
+	// saveState writes the state to STATE_FILE, if set, after it changed.
+	saveState := func() {
+		if c.StateFile == "" {
+			return
+		}
+		st.RoundName, st.RoundNumber, st.RoundPoints = rounds.name, rounds.number, rounds.points
+		st.Activity = activity
+		if c.TurfCountryLabel {
+			st.Countries = userLabels.Values("country")
+		}
+		if c.TurfAnonymizeUsers == "alias" {
+			st.Aliases = userAliases.Aliases()
+		}
+		if err := st.Save(c.StateFile); err != nil {
+			slog.Error("An Error Occured, could not save STATE_FILE", "err", err)
+		}
+	}
+
+	users := e.watched
+	users.Set(refs)
+	pollWorkers = newWorkerPool(c.ApiWorkers)
+	updateConfigMetrics(c, len(refs))
 
+	client := newTurfClient(c)
+
+	batches := &userBatches{}
+	if len(c.TurfPriorityUsers) > 0 {
+		if batches.priority, err = parseUserRefs(c.TurfPriorityUsers); err != nil {
+			fatal(err.Error())
+		}
+	}
+	// fetchBatch fetches the users that batch selects of the watched users and returns all users.
+	fetchBatch := func(ctx context.Context, batch func([]turf.UserRef) []turf.UserRef) ([]turf.User, error) {
+		watched := users.Get()
+		refs := batch(watched)
+		if len(refs) == 0 {
+			return batches.Merge(watched, refs, nil), nil
+		}
+
+		start := time.Now()
+		data, err := client.Users(ctx, refs)
+		observeCycle(time.Since(start), len(refs), data, err)
 		if err != nil {
-			turfgameApiRequestsTotal.WithLabelValues("error").Inc()
-			log.Printf("An Error Occured %v", err)
-		} else {
-			turfgameApiRequestsTotal.WithLabelValues("ok").Inc()
-			log.Printf("Sucessfully called %s in %v seconds", c.TurfApiEndpoint, duration.Seconds())
+			return nil, err
+		}
+		return batches.Merge(watched, refs, data), nil
+	}
 
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				turfgameApiRequestsTotal.WithLabelValues("error").Inc()
-				log.Println(err)
+	// Users fetched on scrape or on /-/poll while they are being polled share the poll.
+	fetchUsers := coalesce(func(ctx context.Context) ([]turf.User, error) {
+		return fetchBatch(ctx, slices.Clone)
+	})
+	validated, ok := validateUsers(ctx, c, fetchUsers, refs)
+	// With TURF_PRIORITY_USERS those are polled apart, at PRIORITY_POLL_INTERVAL_SEC.
+	pollUsers := fetchUsers
+	if len(batches.priority) > 0 {
+		pollUsers = func(ctx context.Context) ([]turf.User, error) {
+			return fetchBatch(ctx, batches.Others)
+		}
+		go poll(ctx, c, c.schedule(c.PriorityIntervalSec), c.TurfApiEndpoint, func(ctx context.Context) ([]turf.User, error) {
+			return fetchBatch(ctx, batches.Priority)
+		}, ch)
+	}
+	var usersSchedule schedule = c.schedule(c.UsersIntervalSec)
+	adaptive := newAdaptiveSchedule(time.Duration(c.AdaptiveMinSec)*time.Second, time.Duration(c.AdaptiveMaxSec)*time.Second)
+	if c.AdaptivePolling {
+		usersSchedule = adaptive
+	}
+	if ok {
+		// The users were just fetched to validate them.
+		pollUsers = seeded(validated, pollUsers)
+	}
+	go poll(ctx, c, usersSchedule, c.TurfApiEndpoint, pollUsers, ch)
+
+	if len(c.TurfZones) > 0 {
+		var zones []turf.ZoneRef
+		for _, z := range c.TurfZones {
+			zones = append(zones, turf.ZoneRef{Name: z})
+		}
+
+		go poll(ctx, c, c.schedule(c.ZonesIntervalSec), c.TurfZonesApiEndpoint, func(ctx context.Context) ([]turf.Zone, error) {
+			return client.Zones(ctx, zones)
+		}, zoneCh)
+	}
+
+	if c.TurfRoundsEnabled {
+		go poll(ctx, c, c.schedule(c.RoundsIntervalSec), c.TurfRoundsEndpoint, client.Rounds, roundCh)
+	}
+
+	if len(c.TurfRegions) > 0 {
+		go poll(ctx, c, c.schedule(c.RegionsIntervalSec), c.TurfRegionsEndpoint, conditional(c, client.Regions), regionCh)
+	}
+
+	if len(c.TurfRegionZones) > 0 {
+		go poll(ctx, c, c.schedule(c.ZonesIntervalSec), strings.TrimSuffix(c.TurfZonesApiEndpoint, "/")+"/all", conditional(c, client.AllZones), allZonesCh)
+	}
+
+	watched := newWatchedUsers(refs)
+
+	for _, feed := range c.TurfFeeds {
+		if !slices.Contains([]string{"takeover", "medal", "chat"}, feed) {
+			fatal("Unsupported feed in TURF_FEEDS", "feed", feed)
+		}
+
+		go pollFeed(ctx, c, client, feed, st.FeedCursors[feed], feedCh)
+	}
+
+	for _, u := range watched.names {
+		initFeedCounters(c, u)
+	}
+
+	if c.TurfStatsEnabled {
+		go poll(ctx, c, c.schedule(c.StatsIntervalSec), c.TurfStatsEndpoint, conditional(c, client.Statistics), statsCh)
+	}
+
+	for _, scope := range c.TurfToplists {
+		if _, _, err := turf.ParseScope(scope); err != nil {
+			fatal(err.Error())
+		}
+
+		scopeCh := make(chan []turf.User)
+		go poll(ctx, c, c.schedule(c.ToplistsIntervalSec), c.TurfToplistEndpoint, func(ctx context.Context) ([]turf.User, error) {
+			return client.Toplist(ctx, scope, c.TurfToplistSize)
+		}, scopeCh)
+		go func() {
+			for users := range scopeCh {
+				toplistCh <- toplist{Scope: scope, Users: users}
 			}
+		}()
+	}
+
+	// handleUsers updates the user metrics, whether the users were polled or fetched on scrape.
+	handleUsers := func(data []turf.User) {
+		fetched := time.Now()
+		e.Fetched(fetched)
 
-			err = json.Unmarshal(body, &turfData)
+		if !c.TurfRoundsEnabled && rounds.ObservePoints(data) {
+			slog.Info("New round detected, points of watched users were reset")
+			roundChanges.Inc()
+		}
 
+		if c.TurfCountryLabel {
+			updateCountryLabels(c, data)
+		}
+
+		e.SetUsers(data, rounds.name, fetched)
+		// systemd waits for the first users to be exported and, with WatchdogSec, restarts the
+		// exporter if it stops exporting them.
+		sdNotify("READY=1\nWATCHDOG=1")
+		updateUserFound(users.Get(), data)
+		updateTeamMetrics(data, t)
+		for _, user := range data {
+			// A growing number of taken zones means the user made a takeover since the last poll.
+			if prev, ok := taken[user.Name]; ok && user.Taken > prev {
+				activity.Observe(user.Name, time.Now())
+			}
+			totalPointsCounter.With(userLabels.For(user.Name)).Add(float64(totals.Delta(user.Name, user.TotalPoints)))
+			takenZonesCounter.With(userLabels.For(user.Name)).Add(float64(taken.Delta(user.Name, user.Taken)))
+			gained, lost := owned.Diff(user.Name, user.Zones)
+			zonesGained.With(userLabels.For(user.Name)).Add(float64(gained))
+			zonesLost.With(userLabels.For(user.Name)).Add(float64(lost))
+		}
+		adaptive.Active(activity.Latest())
+		if c.TurfUserZones {
+			go resolveUserZones(ctx, client, data, userZonesCh)
+		}
+		saveState()
+	}
+
+	// The loop beats regularly while it isn't stuck, see /healthz.
+	heartbeat := time.NewTicker(10 * time.Second)
+	defer heartbeat.Stop()
+	e.Beat()
+
+	for {
+		select {
+		case <-heartbeat.C:
+			e.Beat()
+		case data := <-ch:
+			handleUsers(data)
+		case req := <-e.refreshCh:
+			pollCtx := ctx
+			if req.ok {
+				pollCtx = withSpan(ctx, req.span)
+			}
+			data, err := fetchUsers(pollCtx)
+			observePoll(c.TurfApiEndpoint, err)
 			if err != nil {
-				turfgameApiRequestsTotal.WithLabelValues("error").Inc()
-				log.Println(err)
+				slog.Error("An Error Occured", "endpoint", c.TurfApiEndpoint, "error_class", errorClass(err), "err", err)
+			} else {
+				handleUsers(data)
+			}
+			close(req.done)
+		case refs := <-e.watchCh:
+			updateConfigMetrics(c, len(refs))
+			prev := watched
+			watched = newWatchedUsers(refs)
+
+			removed := e.RemoveUnwatched(watched)
+			for lower, name := range prev.names {
+				if _, ok := watched.names[lower]; !ok && !slices.Contains(removed, name) {
+					removed = append(removed, name)
+				}
+			}
+			for _, name := range removed {
+				slog.Info("User is no longer watched", "user", name)
+				deleteUserSeries(name)
+				delete(totals, name)
+				delete(taken, name)
+				delete(owned, name)
+				delete(activity, name)
+			}
+			for _, u := range watched.names {
+				initFeedCounters(c, u)
+			}
+			if c.PersistUsers {
+				st.Users = make([]string, 0, len(refs))
+				for _, ref := range refs {
+					st.Users = append(st.Users, formatUserRef(ref))
+				}
 			}
+			saveState()
+		case data := <-userZonesCh:
+			updateUserZoneMetrics(data)
+			setOwnedZones(data)
+		case data := <-zoneCh:
+			updateZoneMetrics(data, time.Now())
+		case data := <-roundCh:
+			name, number, ok := updateRoundMetrics(data, time.Now())
+			if !ok {
+				break
+			}
+
+			roundNumber.Set(float64(number))
+			known := rounds.name != ""
+			if rounds.ObserveRound(name, number) && known {
+				slog.Info("New round started", "round", name)
+				roundChanges.Inc()
+			}
+			saveState()
+		case data := <-allZonesCh:
+			updateRegionZoneMetrics(data, c.TurfRegionZones, watched)
+		case data := <-regionCh:
+			updateRegionMetrics(data, c.TurfRegions)
+		case data := <-toplistCh:
+			updateToplistMetrics(data, c.TurfToplistSize, watched)
+		case data := <-statsCh:
+			statsZones.Set(float64(data.TotalZones))
+			statsPlayers.Set(float64(data.TotalUsers))
+			statsZonesTakenToday.Set(float64(data.ZonesTakenToday))
+		case data := <-feedCh:
+			updateFeedMetrics(data.items, watched, activity)
+			adaptive.Active(activity.Latest())
+			st.FeedCursors[data.feed] = data.items[len(data.items)-1].Time.Time
+			saveState()
+		}
+	}
+}
+
+// updateUserFound exports which of the configured users are in users. Users that are
+// found are labelled with their name in users, missing users as by formatUserRef.
+func updateUserFound(refs []turf.UserRef, users []turf.User) {
+	userFound.Reset()
+	for _, ref := range refs {
+		if i := findUser(users, ref); i >= 0 {
+			userFound.With(userLabels.For(users[i].Name)).Set(1)
+		} else {
+			userFound.With(userLabels.For(formatUserRef(ref))).Set(0)
+		}
+	}
+}
+
+// updateConfigMetrics exports the configuration with the number of watched users.
+func updateConfigMetrics(c Config, users int) {
+	configInfo.Reset()
+	configInfo.WithLabelValues(strconv.Itoa(c.PollIntervalSec), c.TurfApiEndpoint, strconv.Itoa(users)).Set(1)
+	configuredUsers.Set(float64(users))
+}
 
-			ch <- turfData
+// updateCountryLabels sets the country label of users to their country. The series with
+// the previous country, or those created before the country was known, would otherwise
+// linger.
+func updateCountryLabels(c Config, users []turf.User) {
+	for _, user := range users {
+		if prev, changed := userLabels.Set(user.Name, "country", user.Country); changed {
+			deleteUserSeries(user.Name, "country", prev)
+			initFeedCounters(c, user.Name)
 		}
+	}
+}
 
-		time.Sleep(time.Duration(c.PollIntervalSec) * time.Second)
+// initFeedCounters creates the counters of user from the configured feeds, so that they
+// are exported before the first event.
+func initFeedCounters(c Config, user string) {
+	for _, feed := range c.TurfFeeds {
+		switch feed {
+		case "takeover":
+			userTakeovers.With(userLabels.For(user))
+			userAssists.With(userLabels.For(user))
+		case "medal":
+			userMedalEvents.With(userLabels.For(user))
+		}
+	}
+}
+
+// collectUserMetrics sends the metrics of user, as of the last users snapshot, on ch.
+func collectUserMetrics(ch chan<- prometheus.Metric, user userSnapshot, round string) {
+	labels := user.labels
+	ch <- roundPoints.metric(float64(user.Points), labels, "round", round)
+	ch <- zonesOwned.metric(float64(len(user.Zones)), labels)
+	ch <- pointsPerHour.metric(float64(user.PointsPerHour), labels)
+	ch <- blocktime.metric(float64(user.Blocktime), labels)
+	// The API reports blocktime in minutes.
+	ch <- blocktimeSeconds.metric(float64(user.Blocktime*60), labels)
+	ch <- takenZones.metric(float64(user.Taken), labels)
+	ch <- totalPoints.metric(float64(user.TotalPoints), labels)
+	ch <- userRank.metric(float64(user.Rank), labels)
+	ch <- place.metric(float64(user.Place), labels)
+	ch <- uniqueZones.metric(float64(user.UniqueZonesTaken), labels)
+	if left, ok := uniqueZonesToNextMedal(user.UniqueZonesTaken); ok {
+		ch <- uniqueZonesToMedal.metric(float64(left), labels)
+	}
+	ch <- medalsTaken.metric(float64(len(user.Medals)), labels)
+	// Only the current region is sent, so a previous region disappears once the user moves.
+	ch <- region.metric(1, labels, "region", user.Region.Name)
+	ch <- userInfo.metric(1, labels, "id", userAliases.Id(user.Id), "country", user.Country, "region", user.Region.Name)
+}
+
+func updateZoneMetrics(zones []turf.Zone, now time.Time) {
+	for _, zone := range zones {
+		zoneTakeovers.WithLabelValues(zone.Name).Set(float64(zone.TotalTakeovers))
+		zonePointsPerHour.WithLabelValues(zone.Name).Set(float64(zone.PointsPerHour))
+		zoneTakePoints.WithLabelValues(zone.Name).Set(float64(zone.TakeoverPoints))
+		zoneOwner.DeletePartialMatch(prometheus.Labels{"zone": zone.Name})
+		zoneOwner.WithLabelValues(zone.Name, userAliases.Name(zone.CurrentOwner.Name)).Set(1)
+		zoneHeld.DeletePartialMatch(prometheus.Labels{"zone": zone.Name})
+		if !zone.DateLastTaken.IsZero() {
+			zoneLastTaken.WithLabelValues(zone.Name).Set(float64(zone.DateLastTaken.Unix()))
+			zoneHeld.WithLabelValues(zone.Name, userAliases.Name(zone.CurrentOwner.Name)).Set(now.Sub(zone.DateLastTaken.Time).Seconds())
+		}
+	}
+}
+
+func updateUserZoneMetrics(owned []userZones) {
+	for _, u := range owned {
+		pph := 0
+		userZoneOwned.DeletePartialMatch(prometheus.Labels{"user": userAliases.Name(u.User)})
+		for _, zone := range u.Zones {
+			userZoneOwned.With(userLabels.For(u.User, "zone_name", zone.Name, "zone_id", strconv.Itoa(zone.Id))).Set(1)
+			pph += zone.PointsPerHour
+		}
+		userOwnedZonesPph.With(userLabels.For(u.User)).Set(float64(pph))
+	}
+}
+
+// resolveUserZones looks up the zone IDs owned by users in the zones endpoint.
+func resolveUserZones(ctx context.Context, client TurfClient, users []turf.User, ch chan []userZones) {
+	var ids []turf.ZoneRef
+	for _, user := range users {
+		for _, id := range user.Zones {
+			ids = append(ids, turf.ZoneRef{Id: id})
+		}
 	}
+
+	byId := make(map[int]turf.Zone, len(ids))
+	if len(ids) > 0 {
+		zones, err := client.Zones(ctx, ids)
+		if err != nil {
+			slog.Error("An Error Occured", "error_class", errorClass(err), "err", err)
+			return
+		}
+
+		for _, zone := range zones {
+			byId[zone.Id] = zone
+		}
+	}
+
+	var owned []userZones
+	for _, user := range users {
+		u := userZones{User: user.Name}
+		for _, id := range user.Zones {
+			if zone, ok := byId[id]; ok {
+				u.Zones = append(u.Zones, zone)
+			}
+		}
+		owned = append(owned, u)
+	}
+
+	ch <- owned
+}
+
+// updateRegionMetrics exports the regions whose names are listed in watched.
+// The regions endpoint always returns every region, so the rest are dropped here.
+func updateRegionMetrics(regions []turf.Region, watched []string) {
+	for _, r := range regions {
+		if !slices.ContainsFunc(watched, func(name string) bool { return strings.EqualFold(name, r.Name) }) {
+			continue
+		}
+
+		regionInfo.DeletePartialMatch(prometheus.Labels{"region": r.Name})
+		regionInfo.WithLabelValues(r.Name, strconv.Itoa(r.Id), r.Country, r.Area.Name).Set(1)
+		regionZones.WithLabelValues(r.Name).Set(float64(r.ZoneCount))
+		regionLord.DeletePartialMatch(prometheus.Labels{"region": r.Name})
+		if r.RegionLord.Name != "" {
+			regionLord.WithLabelValues(r.Name, userAliases.Name(r.RegionLord.Name)).Set(1)
+		}
+	}
+}
+
+// updateRegionZoneMetrics aggregates all zones of the regions listed in regions.
+func updateRegionZoneMetrics(zones []turf.Zone, regions []string, watched watchedUsers) {
+	type stats struct {
+		name                  string
+		neutral, watched, pph int
+	}
+
+	byRegion := make(map[string]*stats)
+	for _, r := range regions {
+		byRegion[strings.ToLower(r)] = &stats{name: r}
+	}
+
+	for _, zone := range zones {
+		st, ok := byRegion[strings.ToLower(zone.Region.Name)]
+		if !ok {
+			continue
+		}
+
+		st.name = zone.Region.Name
+		st.pph += zone.PointsPerHour
+		if zone.CurrentOwner.Name == "" {
+			st.neutral++
+		} else if _, ok := watched.Lookup(zone.CurrentOwner.Name, zone.CurrentOwner.Id); ok {
+			st.watched++
+		}
+	}
+
+	for _, st := range byRegion {
+		regionNeutralZones.WithLabelValues(st.name).Set(float64(st.neutral))
+		regionWatchedZones.WithLabelValues(st.name).Set(float64(st.watched))
+		regionPointsPerHour.WithLabelValues(st.name).Set(float64(st.pph))
+	}
+}
+
+// updateToplistMetrics exports the toplist and, for country toplists, the place of the
+// watched users found on it. Watched users below the top size players get no place.
+func updateToplistMetrics(t toplist, size int, watched watchedUsers) {
+	kind, country, _ := strings.Cut(t.Scope, "/")
+	if kind == "country" {
+		userCountryPlace.DeletePartialMatch(prometheus.Labels{"country": country})
+	}
+
+	toplistPoints.DeletePartialMatch(prometheus.Labels{"scope": t.Scope})
+	for i, user := range t.Users {
+		if i >= size {
+			break
+		}
+		toplistPoints.WithLabelValues(t.Scope, strconv.Itoa(i+1), userAliases.Name(user.Name)).Set(float64(user.Points))
+
+		if name, ok := watched.Lookup(user.Name, user.Id); ok && kind == "country" {
+			userCountryPlace.With(userLabels.For(name, "country", country)).Set(float64(i + 1))
+		}
+	}
+}
+
+// poll calls fetch as scheduled by s and passes successful results on ch until ctx is
+// cancelled. url is the endpoint that fetch requests.
+func poll[T any](ctx context.Context, c Config, s schedule, url string, fetch func(context.Context) (T, error), ch chan T) {
+	for {
+		data, err := fetchWithRetries(ctx, c, fetch)
+		if ctx.Err() != nil {
+			return
+		}
+		// Nothing changed since the previous poll, so there is nothing to update.
+		notModified := errors.Is(err, turf.ErrNotModified)
+		if notModified {
+			err = nil
+		}
+		observePoll(url, err)
+		if err != nil {
+			slog.Error("An Error Occured", "endpoint", url, "error_class", errorClass(err), "err", err)
+		} else if !notModified {
+			ch <- data
+		}
+
+		if !waitNext(ctx, s, err) {
+			return
+		}
+	}
+}
+
+// seeded returns fetch, except that its first call returns data instead of fetching.
+func seeded[T any](data T, fetch func(context.Context) (T, error)) func(context.Context) (T, error) {
+	var used atomic.Bool
+	return func(ctx context.Context) (T, error) {
+		if !used.Swap(true) {
+			return data, nil
+		}
+		return fetch(ctx)
+	}
+}
+
+// conditional makes the GET requests of fetch conditional if CONDITIONAL_REQUESTS_ENABLED is
+// set, see turf.WithConditional. Only use it for fetches whose handling doesn't depend on
+// the time of the poll, as unchanged results are not handled again.
+func conditional[T any](c Config, fetch func(context.Context) (T, error)) func(context.Context) (T, error) {
+	if !c.ConditionalRequests {
+		return fetch
+	}
+	return func(ctx context.Context) (T, error) {
+		return fetch(turf.WithConditional(ctx))
+	}
+}
+
+// sleep waits for d and reports whether it did so without ctx being cancelled.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// pollWorkers bounds the number of polls that request the API at the same time.
+var pollWorkers workerPool
+
+// workerPool is a pool of workers, each of which can do one thing at a time.
+type workerPool chan struct{}
+
+func newWorkerPool(size int) workerPool {
+	return make(workerPool, max(size, 1))
+}
+
+// doWork calls fn once a worker of pool is free.
+func doWork[T any](ctx context.Context, pool workerPool, fn func(context.Context) (T, error)) (T, error) {
+	select {
+	case pool <- struct{}{}:
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+	busyWorkers.Inc()
+	defer func() {
+		busyWorkers.Dec()
+		<-pool
+	}()
+
+	return fn(ctx)
+}
+
+// coalesce returns a function that calls fetch, except that calls made while another is
+// in flight wait for it and share its result.
+func coalesce[T any](fetch func(context.Context) (T, error)) func(context.Context) (T, error) {
+	type call struct {
+		done chan struct{}
+		data T
+		err  error
+	}
+	var (
+		mu       sync.Mutex
+		inflight *call
+	)
+
+	return func(ctx context.Context) (T, error) {
+		mu.Lock()
+		if cl := inflight; cl != nil {
+			mu.Unlock()
+			<-cl.done
+			return cl.data, cl.err
+		}
+		cl := &call{done: make(chan struct{})}
+		inflight = cl
+		mu.Unlock()
+
+		cl.data, cl.err = fetch(ctx)
+		mu.Lock()
+		inflight = nil
+		mu.Unlock()
+		close(cl.done)
+		return cl.data, cl.err
+	}
+}
+
+// fetchWithRetries calls fetch and retries it up to API_RETRIES times, waiting a little
+// longer each time, if it fails in a way that may be transient.
+func fetchWithRetries[T any](ctx context.Context, c Config, fetch func(context.Context) (T, error)) (T, error) {
+	for attempt := 1; ; attempt++ {
+		data, err := doWork(ctx, pollWorkers, fetch)
+		if err == nil || attempt > c.ApiRetries || !retryable(err) {
+			return data, err
+		}
+
+		slog.Warn("An Error Occured, retrying", "attempt", attempt, "error_class", errorClass(err), "err", err)
+		apiRetries.Inc()
+		if !sleep(ctx, time.Duration(attempt*c.ApiRetryDelaySec)*time.Second) {
+			return data, err
+		}
+	}
+}
+
+// retryable reports whether a request that failed with err is worth retrying right away.
+// Only errors that may be transient are: the API couldn't be reached or answered with a
+// server error. Client errors, rate limits and responses that couldn't be decoded would
+// fail the same way again.
+func retryable(err error) bool {
+	if errors.Is(err, errCircuitOpen) || errors.Is(err, context.Canceled) || errors.Is(err, turf.ErrNotModified) {
+		return false
+	}
+	switch errorClass(err) {
+	case "dns", "timeout", "connection_refused", "5xx", "error":
+		return true
+	default:
+		return false
+	}
+}
+
+// schedule returns a schedule polling every intervalSec, or PollIntervalSec if it's 0.
+func (c Config) schedule(intervalSec int) fixedSchedule {
+	if intervalSec <= 0 {
+		intervalSec = c.PollIntervalSec
+	}
+	return fixedSchedule(time.Duration(intervalSec) * time.Second)
+}
+
+// pollDelay returns the time to wait before the next poll after a poll that returned err.
+// If the API rate limited the poll, the wait is extended to what the API asked for.
+func pollDelay(interval time.Duration, err error) time.Duration {
+	delay := interval
+
+	var rl *turf.RateLimitError
+	if errors.As(err, &rl) {
+		delay = max(delay, rl.RetryAfter)
+	}
+	return delay
+}
+
+// errorReason returns the reason label of an error response, the HTTP status text. The
+// message of the API is free text and would add a series per message, it is logged instead.
+func errorReason(err *turf.StatusError) string {
+	if reason := http.StatusText(err.StatusCode); reason != "" {
+		return reason
+	}
+	return "Unknown Status"
+}
+
+// errorClass classifies the error of a request: dns, timeout, connection_refused or tls if
+// the API couldn't be reached, 4xx or 5xx if it answered with an error, decode_error if
+// the response couldn't be decoded and error otherwise.
+func errorClass(err error) string {
+	var (
+		statusErr *turf.StatusError
+		rl        *turf.RateLimitError
+		dnsErr    *net.DNSError
+		netErr    net.Error
+		certErr   *tls.CertificateVerificationError
+		alertErr  tls.AlertError
+		recordErr tls.RecordHeaderError
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &statusErr):
+		return strconv.Itoa(statusErr.StatusCode/100) + "xx"
+	case errors.As(err, &rl):
+		return "4xx"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.As(err, &certErr) || errors.As(err, &alertErr) || errors.As(err, &recordErr):
+		return "tls"
+	case errors.Is(err, turf.ErrResponseTooLarge) || errors.As(err, &syntaxErr) || errors.As(err, &typeErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return "decode_error"
+	default:
+		return "error"
+	}
+}
+
+// observePoll records the outcome of a poll of url.
+func observePoll(url string, err error) {
+	endpoint, _, _ := strings.Cut(url, "?")
+	if err != nil {
+		lastPollError.WithLabelValues(endpoint).Set(1)
+		return
+	}
+
+	lastPollError.WithLabelValues(endpoint).Set(0)
+	lastSuccessfulPoll.WithLabelValues(endpoint).SetToCurrentTime()
+}
+
+// pollSummary is the summary of the last poll of the users published on /debug/vars.
+type pollSummary struct {
+	Time        time.Time `json:"time"`
+	DurationSec float64   `json:"duration_sec"`
+	Result      string    `json:"result"`
+	Wanted      int       `json:"wanted"`
+	Returned    int       `json:"returned"`
+	Error       string    `json:"error,omitempty"`
+}
+
+var (
+	// pollResults counts the polls of the users by result, as turfgame_polls_total.
+	pollResults = expvar.NewMap("turfgame_polls")
+	lastPoll    atomic.Pointer[pollSummary]
+)
+
+// observeCycle records a poll of wanted users that took d and returned data.
+func observeCycle(d time.Duration, wanted int, data []turf.User, err error) {
+	pollDuration.Observe(d.Seconds())
+	summary := &pollSummary{Time: time.Now(), DurationSec: d.Seconds(), Wanted: wanted, Returned: len(data)}
+	switch {
+	case err != nil:
+		summary.Result = "error"
+		summary.Error = err.Error()
+	case len(data) < wanted:
+		summary.Result = "partial"
+	default:
+		summary.Result = "success"
+	}
+	polls.WithLabelValues(summary.Result).Inc()
+	pollResults.Add(summary.Result, 1)
+	lastPoll.Store(summary)
+	slog.Debug("Polled the users", "users", wanted, "returned", len(data), "result", summary.Result, "duration_seconds", d.Seconds())
+	if err == nil {
+		pollUsersReturned.Set(float64(len(data)))
+	}
+}
+
+// observeRequest records a request to the Turf API that took d. resp is nil if no
+// response was received. Requests with a trace are recorded with the trace ID as exemplar.
+func observeRequest(req *http.Request, resp *http.Response, d time.Duration, err error) {
+	exemplar := traceExemplar(req)
+
+	// The path leaves out the query, so that e.g. feed cursors don't create a new series per request.
+	statusClass := ""
+	if resp != nil {
+		statusClass = strconv.Itoa(resp.StatusCode/100) + "xx"
+	}
+	observeWithExemplar(requestDurations.WithLabelValues(req.Method, req.URL.Path, statusClass), d.Seconds(), exemplar)
+
+	var rl *turf.RateLimitError
+	if errors.As(err, &rl) {
+		rateLimited.Inc()
+	}
+
+	var (
+		syntaxErr *json.SyntaxError
+		statusErr *turf.StatusError
+	)
+	switch {
+	case errors.As(err, &statusErr):
+		apiErrors.WithLabelValues(errorReason(statusErr)).Inc()
+		slog.Warn("The Turf API answered with an error", "endpoint", req.URL, "status", statusErr.StatusCode, "message", statusErr.Message)
+	case errors.Is(err, turf.ErrResponseTooLarge):
+		invalidResponses.WithLabelValues("too_large").Inc()
+	case errors.As(err, &syntaxErr):
+		invalidResponses.WithLabelValues("not_json").Inc()
+	}
+
+	if errors.Is(err, turf.ErrNotModified) {
+		addWithExemplar(turfgameApiRequestsTotal.WithLabelValues(req.Method, req.URL.Path, "not_modified", statusClass), 1, exemplar)
+		return
+	}
+	if err != nil {
+		addWithExemplar(turfgameApiRequestsTotal.WithLabelValues(req.Method, req.URL.Path, errorClass(err), statusClass), 1, exemplar)
+		return
+	}
+
+	addWithExemplar(turfgameApiRequestsTotal.WithLabelValues(req.Method, req.URL.Path, "ok", statusClass), 1, exemplar)
+	// The response has the request actually sent, e.g. to another URL of TURF_API_URL.
+	slog.Info("Called the Turf API", "endpoint", resp.Request.URL, "status", resp.StatusCode, "duration_seconds", d.Seconds())
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+)
+
+func TestErrorClass(t *testing.T) {
+	// wrap wraps err like the errors returned by http.Client.
+	wrap := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://api.turfgame.com/unstable/users", Err: err}
+	}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&turf.StatusError{StatusCode: 400, Message: "Unknown user"}, "4xx"},
+		{fmt.Errorf("users: %w", &turf.StatusError{StatusCode: 503}), "5xx"},
+		{&turf.RateLimitError{}, "4xx"},
+		{wrap(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "api.turfgame.com"}}), "dns"},
+		{wrap(context.DeadlineExceeded), "timeout"},
+		{wrap(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), "connection_refused"},
+		{wrap(&tls.CertificateVerificationError{Err: errors.New("expired")}), "tls"},
+		{turf.ErrResponseTooLarge, "decode_error"},
+		{json.Unmarshal([]byte("<html>"), new(any)), "decode_error"},
+		{json.Unmarshal([]byte(`{"name": 1}`), new(struct{ Name string })), "decode_error"},
+		{errors.New("something else"), "error"},
+	}
+	for _, tt := range tests {
+		if got := errorClass(tt.err); got != tt.want {
+			t.Errorf("errorClass(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&turf.StatusError{StatusCode: 503}, true},
+		{&url.Error{Op: "Post", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, true},
+		{&url.Error{Op: "Post", Err: context.DeadlineExceeded}, true},
+		{errors.New("unexpected EOF"), true},
+		{&turf.StatusError{StatusCode: 400, Message: "Unknown user"}, false},
+		{&turf.RateLimitError{}, false},
+		{turf.ErrResponseTooLarge, false},
+		{json.Unmarshal([]byte("<html>"), new(any)), false},
+		{&url.Error{Op: "Post", Err: &tls.CertificateVerificationError{Err: errors.New("expired")}}, false},
+		{errCircuitOpen, false},
+		{context.Canceled, false},
+		{turf.ErrNotModified, false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestErrorReason(t *testing.T) {
+	tests := []struct {
+		err  *turf.StatusError
+		want string
+	}{
+		{&turf.StatusError{StatusCode: 400, Message: "Unknown user Alice"}, "Bad Request"},
+		{&turf.StatusError{StatusCode: 503}, "Service Unavailable"},
+		{&turf.StatusError{StatusCode: 599, Message: "Oops"}, "Unknown Status"},
+	}
+	for _, tt := range tests {
+		if got := errorReason(tt.err); got != tt.want {
+			t.Errorf("errorReason(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSeeded(t *testing.T) {
+	calls := 0
+	fetch := seeded([]string{"seed"}, func(context.Context) ([]string, error) {
+		calls++
+		return []string{"fetched"}, nil
+	})
+
+	for i, want := range []string{"seed", "fetched", "fetched"} {
+		got, err := fetch(context.Background())
+		if err != nil || len(got) != 1 || got[0] != want {
+			t.Errorf("call %d: got %v, %v, want %s", i, got, err, want)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("got %d fetches, want 2", calls)
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhose/go-turfgame-exporter/pkg/turf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// parseUserRef parses a configured user, either a name or, when given as "id:<id>", the
+// users Turf ID which stays the same if the user is renamed.
+func parseUserRef(s string) (turf.UserRef, error) {
+	if v, ok := strings.CutPrefix(s, "id:"); ok {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return turf.UserRef{}, fmt.Errorf("invalid user ID %q", s)
+		}
+		return turf.UserRef{Id: id}, nil
+	}
+
+	return turf.UserRef{Name: s}, nil
+}
+
+// parseUserRefs parses the users of TURF_USERS.
+func parseUserRefs(users []string) ([]turf.UserRef, error) {
+	if len(users) == 0 {
+		return nil, errors.New("TURF_USERS cannot be an empty string")
+	}
+
+	refs := make([]turf.UserRef, 0, len(users))
+	for _, u := range users {
+		user, err := parseUserRef(u)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, user)
+	}
+	return refs, nil
+}
+
+// userList is the list of users sent to the users endpoint. It can be replaced while
+// it's being polled.
+type userList struct {
+	mu   sync.RWMutex
+	refs []turf.UserRef
+}
+
+func (l *userList) Set(refs []turf.UserRef) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refs = refs
+}
+
+func (l *userList) Get() []turf.UserRef {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.refs
+}
+
+// userBatches merges the users polled in batches, with TURF_PRIORITY_USERS polled apart
+// from the others, so that the result of either batch is turned into a snapshot of all users.
+type userBatches struct {
+	priority []turf.UserRef
+	mu       sync.Mutex
+	latest   []turf.User
+}
+
+// Priority returns the priority users among users.
+func (b *userBatches) Priority(users []turf.UserRef) []turf.UserRef {
+	return slices.DeleteFunc(slices.Clone(users), func(ref turf.UserRef) bool {
+		return findUserRef(b.priority, ref) < 0
+	})
+}
+
+// Others returns the users that are not priority users.
+func (b *userBatches) Others(users []turf.UserRef) []turf.UserRef {
+	return slices.DeleteFunc(slices.Clone(users), func(ref turf.UserRef) bool {
+		return findUserRef(b.priority, ref) >= 0
+	})
+}
+
+// Merge returns data, fetched for the users of batch, with the latest data of the other
+// users in watched.
+func (b *userBatches) Merge(watched, batch []turf.UserRef, data []turf.User) []turf.User {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	merged := slices.Clone(data)
+	for _, u := range b.latest {
+		isUser := func(ref turf.UserRef) bool { return refersTo(ref, u) }
+		if !slices.ContainsFunc(batch, isUser) && slices.ContainsFunc(watched, isUser) {
+			merged = append(merged, u)
+		}
+	}
+	b.latest = merged
+	return merged
+}
+
+// watchedUsers resolves users seen in API responses to the configured users.
+type watchedUsers struct {
+	// names maps lower-cased Turf usernames to the name used in metric labels.
+	names map[string]string
+	ids   map[int]bool
+}
+
+func newWatchedUsers(users []turf.UserRef) watchedUsers {
+	w := watchedUsers{
+		names: make(map[string]string),
+		ids:   make(map[int]bool),
+	}
+	for _, u := range users {
+		if u.Id != 0 {
+			w.ids[u.Id] = true
+		} else {
+			w.names[strings.ToLower(u.Name)] = u.Name
+		}
+	}
+	return w
+}
+
+// Lookup returns the label for the user if it's watched. Users configured by ID are
+// labelled with their current name.
+func (w watchedUsers) Lookup(name string, id int) (string, bool) {
+	if label, ok := w.names[strings.ToLower(name)]; ok {
+		return label, true
+	}
+	if id != 0 && w.ids[id] {
+		return name, true
+	}
+	return "", false
+}
+
+// findUserRef returns the index of ref in refs, or -1.
+func findUserRef(refs []turf.UserRef, ref turf.UserRef) int {
+	return slices.IndexFunc(refs, func(r turf.UserRef) bool {
+		return (ref.Id != 0 && r.Id == ref.Id) || (ref.Id == 0 && r.Id == 0 && strings.EqualFold(r.Name, ref.Name))
+	})
+}
+
+// findUser returns the index of the user referred to by ref in users, or -1.
+func findUser(users []turf.User, ref turf.UserRef) int {
+	return slices.IndexFunc(users, func(u turf.User) bool {
+		return refersTo(ref, u)
+	})
+}
+
+// refersTo reports whether ref refers to u.
+func refersTo(ref turf.UserRef, u turf.User) bool {
+	return (ref.Id != 0 && u.Id == ref.Id) || (ref.Id == 0 && strings.EqualFold(u.Name, ref.Name))
+}
+
+// formatUserRef returns ref as given in TURF_USERS, "id:<id>" if it's referred to by ID.
+func formatUserRef(ref turf.UserRef) string {
+	if ref.Id != 0 {
+		return "id:" + strconv.Itoa(ref.Id)
+	}
+	return ref.Name
+}
+
+// validateUsers looks up the configured users at startup with fetch and logs those that
+// the API doesn't know, e.g. because of a typo. With TURF_USERS_VALIDATION=strict the
+// exporter exits instead. If the API can't be reached the users are left unvalidated. It
+// returns the users fetched, if any, so that the first poll doesn't fetch them again.
+func validateUsers(ctx context.Context, c Config, fetch func(context.Context) ([]turf.User, error), refs []turf.UserRef) ([]turf.User, bool) {
+	switch c.TurfUsersValidation {
+	case "off":
+		return nil, false
+	case "warn", "strict":
+	default:
+		fatal("Unsupported TURF_USERS_VALIDATION, expected off, warn or strict", "value", c.TurfUsersValidation)
+	}
+
+	users, err := fetchWithRetries(ctx, c, fetch)
+	observePoll(c.TurfApiEndpoint, err)
+	if err != nil {
+		slog.Error("An Error Occured, could not validate TURF_USERS", "error_class", errorClass(err), "err", err)
+		return nil, false
+	}
+	updateUserFound(refs, users)
+
+	var missing []string
+	for _, ref := range refs {
+		if findUser(users, ref) < 0 {
+			missing = append(missing, formatUserRef(ref))
+		}
+	}
+	if len(missing) == 0 {
+		return users, true
+	}
+	if c.TurfUsersValidation == "strict" {
+		fatal("Users in TURF_USERS not found by the Turf API", "users", strings.Join(missing, ","))
+	}
+	slog.Warn("Users in TURF_USERS not found by the Turf API", "users", strings.Join(missing, ","))
+	return users, true
+}
+
+// counterTracker turns values reported by the API that should only ever grow into
+// counter increments, keyed by user.
+type counterTracker map[string]int
+
+// Delta returns how much value has grown since the previous call for key. The first
+// call returns value itself, so that the counter matches what the API reports. A value
+// lower than the previous one can only be an API glitch and is ignored.
+func (t counterTracker) Delta(key string, value int) int {
+	prev, ok := t[key]
+	if ok && value <= prev {
+		return 0
+	}
+
+	t[key] = value
+	return value - prev
+}
+
+// zoneTracker remembers the zones owned by each user at the previous poll.
+type zoneTracker map[string]map[int]bool
+
+// Diff returns how many of zones are new since the previous call for user and how many
+// zones have been lost. Nothing is counted the first time a user is seen.
+func (t zoneTracker) Diff(user string, zones []int) (gained int, lost int) {
+	current := make(map[int]bool, len(zones))
+	for _, id := range zones {
+		current[id] = true
+	}
+
+	prev, ok := t[user]
+	t[user] = current
+	if !ok {
+		return 0, 0
+	}
+
+	for id := range current {
+		if !prev[id] {
+			gained++
+		}
+	}
+	for id := range prev {
+		if !current[id] {
+			lost++
+		}
+	}
+	return gained, lost
+}
+
+// userLabels are the extra labels added to every per-user metric.
+var userLabels extraUserLabels
+
+// extraUserLabels holds labels, such as team, that are added to every per-user metric
+// after "user".
+type extraUserLabels struct {
+	names []string
+	// values maps lower-cased usernames to their label values.
+	values map[string]map[string]string
+}
+
+// Add adds the label name with values keyed by lower-cased username. Users without
+// a value get an empty label.
+func (l *extraUserLabels) Add(name string, values map[string]string) {
+	if l.values == nil {
+		l.values = make(map[string]map[string]string)
+	}
+
+	l.names = append(l.names, name)
+	for user, value := range values {
+		if l.values[user] == nil {
+			l.values[user] = make(map[string]string)
+		}
+		l.values[user][name] = value
+	}
+}
+
+// Set sets the label name of user to value and reports whether it changed. prev is the
+// previous value, empty if there was none.
+func (l *extraUserLabels) Set(user string, name string, value string) (prev string, changed bool) {
+	if l.values == nil {
+		l.values = make(map[string]map[string]string)
+	}
+
+	user = strings.ToLower(user)
+	if l.values[user] == nil {
+		l.values[user] = make(map[string]string)
+	}
+
+	prev, ok := l.values[user][name]
+	l.values[user][name] = value
+	return prev, !ok || prev != value
+}
+
+// Values returns the values of the label name keyed by lower-cased username, for the
+// users that have one.
+func (l extraUserLabels) Values(name string) map[string]string {
+	values := make(map[string]string)
+	for user, labels := range l.values {
+		if value, ok := labels[name]; ok {
+			values[user] = value
+		}
+	}
+	return values
+}
+
+// Names returns the label names of a per-user metric with the additional labels.
+// Additional labels that are already among the extra labels are left out.
+func (l extraUserLabels) Names(labels ...string) []string {
+	names := append([]string{"user"}, l.names...)
+	for _, label := range labels {
+		if !slices.Contains(names, label) {
+			names = append(names, label)
+		}
+	}
+	return names
+}
+
+// For returns the labels of a per-user metric for user, with the additional labels
+// given as name/value pairs.
+func (l extraUserLabels) For(user string, labelValues ...string) prometheus.Labels {
+	labels := prometheus.Labels{"user": userAliases.Name(user)}
+	for _, name := range l.names {
+		labels[name] = l.values[strings.ToLower(user)][name]
+	}
+	for i := 0; i+1 < len(labelValues); i += 2 {
+		labels[labelValues[i]] = labelValues[i+1]
+	}
+	return labels
+}
+
+// userAliases are the names of the users as exported in labels.
+var userAliases anonymizer
+
+// anonymizer replaces usernames in labels, so that players aren't exposed by name. With
+// "hash" a user is named by a salted hash of the username, with "alias" by a number in the
+// order the users are first seen. The mapping is only kept in memory.
+type anonymizer struct {
+	mode string
+	salt string
+
+	mu sync.Mutex
+	// aliases maps lower-cased usernames to their alias.
+	aliases map[string]string
+}
+
+func newAnonymizer(mode string, salt string) (anonymizer, error) {
+	switch mode {
+	case "", "hash", "alias":
+	default:
+		return anonymizer{}, fmt.Errorf("unsupported TURF_ANONYMIZE_USERS %q", mode)
+	}
+	// Without a salt anyone can hash a list of known usernames and match the labels.
+	if mode == "hash" && salt == "" {
+		return anonymizer{}, fmt.Errorf("TURF_ANONYMIZE_USERS=hash requires TURF_ANONYMIZE_SALT")
+	}
+	return anonymizer{mode: mode, salt: salt, aliases: make(map[string]string)}, nil
+}
+
+// Name returns the name of user to use in labels.
+func (a *anonymizer) Name(user string) string {
+	if a.mode == "" || user == "" {
+		return user
+	}
+
+	key := strings.ToLower(user)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if alias, ok := a.aliases[key]; ok {
+		return alias
+	}
+
+	var alias string
+	switch a.mode {
+	case "hash":
+		sum := sha256.Sum256([]byte(a.salt + key))
+		alias = "user-" + hex.EncodeToString(sum[:6])
+	case "alias":
+		alias = "player-" + strconv.Itoa(len(a.aliases)+1)
+	}
+	a.aliases[key] = alias
+	return alias
+}
+
+// Number names users, as in TURF_USERS, in the order they are listed unless they are
+// already named, so that the aliases follow the configuration.
+func (a *anonymizer) Number(users []string) {
+	for _, u := range users {
+		if !strings.HasPrefix(u, "id:") {
+			a.Name(u)
+		}
+	}
+}
+
+// Aliases returns the aliases of the users seen so far, keyed by lower-cased username.
+func (a *anonymizer) Aliases() map[string]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return maps.Clone(a.aliases)
+}
+
+// Restore replaces the aliases by those returned by Aliases before a restart, so that the
+// users keep their alias. Users seen for the first time are numbered after them.
+func (a *anonymizer) Restore(aliases map[string]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.aliases = maps.Clone(aliases)
+}
+
+// Id returns the Turf ID of a user to use in labels, which is left out when anonymizing
+// since it can be looked up.
+func (a *anonymizer) Id(id int) string {
+	if a.mode != "" {
+		return ""
+	}
+	return strconv.Itoa(id)
+}
+
+// reservedUserLabels are the labels that per-user metrics already have.
+var reservedUserLabels = []string{
+	"user", "team", "country", "round", "region", "id", "zone_name", "zone_id", "medal_id", "medal_name",
+}
+
+// parseUserLabels parses static labels given as "user1:device=phone,alias=Al;user2:device=watch".
+// It returns the label names in the order they first appear, and the values of each label
+// keyed by lower-cased username.
+func parseUserLabels(s string) ([]string, map[string]map[string]string, error) {
+	var names []string
+	values := make(map[string]map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return names, values, nil
+	}
+
+	for _, entry := range strings.Split(s, ";") {
+		user, labels, ok := strings.Cut(entry, ":")
+		user = strings.ToLower(strings.TrimSpace(user))
+		if !ok || user == "" {
+			return nil, nil, fmt.Errorf("invalid user labels %q", entry)
+		}
+
+		for _, label := range strings.Split(labels, ",") {
+			name, value, ok := strings.Cut(label, "=")
+			name = strings.TrimSpace(name)
+			if !ok || !model.LabelName(name).IsValid() {
+				return nil, nil, fmt.Errorf("invalid label %q of user %q", label, user)
+			}
+			if slices.Contains(reservedUserLabels, name) {
+				return nil, nil, fmt.Errorf("label %q of user %q is already used by the exporter", name, user)
+			}
+
+			if values[name] == nil {
+				names = append(names, name)
+				values[name] = make(map[string]string)
+			}
+			values[name][user] = strings.TrimSpace(value)
+		}
+	}
+
+	return names, values, nil
+}
+
+// activityTracker remembers when each user was last seen being active.
+type activityTracker map[string]time.Time
+
+// Observe records activity by user at t and exports it unless newer activity is already known.
+func (a activityTracker) Observe(user string, t time.Time) {
+	if !t.After(a[user]) {
+		return
+	}
+
+	a[user] = t
+	userLastActivity.With(userLabels.For(user)).Set(float64(t.Unix()))
+}
+
+// Latest returns the time of the latest activity of any user, or the zero time if none is known.
+func (a activityTracker) Latest() time.Time {
+	var latest time.Time
+	for _, t := range a {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
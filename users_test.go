@@ -0,0 +1,107 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestParseUserLabels(t *testing.T) {
+	names, values, err := parseUserLabels("Alice:device=phone, alias=Al ;bob:device=watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"device", "alias"}; !slices.Equal(names, want) {
+		t.Errorf("got names %v, want %v", names, want)
+	}
+	if want := map[string]string{"alice": "phone", "bob": "watch"}; !maps.Equal(values["device"], want) {
+		t.Errorf("got device %v, want %v", values["device"], want)
+	}
+	if want := map[string]string{"alice": "Al"}; !maps.Equal(values["alias"], want) {
+		t.Errorf("got alias %v, want %v", values["alias"], want)
+	}
+
+	for _, s := range []string{"alice", ":device=phone", "alice:device", "alice:1device=phone", "alice:team=red"} {
+		if _, _, err := parseUserLabels(s); err == nil {
+			t.Errorf("parseUserLabels(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestCounterTrackerDelta(t *testing.T) {
+	tracker := make(counterTracker)
+	steps := []struct {
+		key   string
+		value int
+		want  int
+	}{
+		{"alice", 100, 100},
+		{"alice", 100, 0},
+		{"alice", 130, 30},
+		// A glitch is ignored, and growth is counted from the highest value seen.
+		{"alice", 90, 0},
+		{"alice", 135, 5},
+		{"bob", 7, 7},
+	}
+	for i, s := range steps {
+		if got := tracker.Delta(s.key, s.value); got != s.want {
+			t.Errorf("step %d: Delta(%q, %d) = %d, want %d", i, s.key, s.value, got, s.want)
+		}
+	}
+}
+
+func TestZoneTrackerDiff(t *testing.T) {
+	tracker := make(zoneTracker)
+	steps := []struct {
+		zones      []int
+		wantGained int
+		wantLost   int
+	}{
+		{[]int{1, 2, 3}, 0, 0},
+		{[]int{1, 2, 3}, 0, 0},
+		{[]int{2, 3, 4, 5}, 2, 1},
+		{nil, 0, 4},
+	}
+	for i, s := range steps {
+		gained, lost := tracker.Diff("alice", s.zones)
+		if gained != s.wantGained || lost != s.wantLost {
+			t.Errorf("step %d: Diff(%v) = %d, %d, want %d, %d", i, s.zones, gained, lost, s.wantGained, s.wantLost)
+		}
+	}
+}
+
+func TestNewAnonymizer(t *testing.T) {
+	if _, err := newAnonymizer("hash", ""); err == nil {
+		t.Error("hash without a salt succeeded, want an error")
+	}
+
+	a, err := newAnonymizer("hash", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newAnonymizer("hash", "other")
+	if a.Name("Alice") != a.Name("alice") || a.Name("Alice") == b.Name("Alice") {
+		t.Errorf("got %q and %q, want the same name regardless of case, differing by salt", a.Name("Alice"), b.Name("Alice"))
+	}
+}
+
+func TestAnonymizerRestore(t *testing.T) {
+	a, _ := newAnonymizer("alias", "")
+	a.Number([]string{"Alice", "id:1002"})
+	// Bob was added at runtime.
+	a.Name("Bob")
+
+	// A restart with Carol added to TURF_USERS.
+	b, _ := newAnonymizer("alias", "")
+	b.Number([]string{"Carol", "Alice", "id:1002"})
+	b.Restore(a.Aliases())
+	b.Number([]string{"Carol", "Alice", "id:1002"})
+
+	want := map[string]string{"alice": "player-1", "bob": "player-2", "carol": "player-3"}
+	if got := b.Aliases(); !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := b.Name("Dave"); got != "player-4" {
+		t.Errorf("got %q for a new user, want player-4", got)
+	}
+}